@@ -1,4 +1,5 @@
-from flask import Flask, make_response, abort, jsonify
+from flask import Flask, make_response, abort, jsonify, request
+import json
 import os
 import requests
 import hashlib
@@ -9,6 +10,7 @@ from flask_caching import Cache
 # Configuration
 USE_GITHUB = os.environ.get('USE_GITHUB', '0') == '1'
 GITHUB_RAW_BASE_URL = "https://raw.githubusercontent.com/huntbase-io/scout-content/main"
+GITHUB_TREE_URL = "https://api.github.com/repos/huntbase-io/scout-content/git/trees/main?recursive=1"
 ALLOWED_OS_DIRS = ["windows", "linux", "darwin"]
 
 # Local directories (used when USE_GITHUB=0)
@@ -43,6 +45,19 @@ def fetch_from_github(path):
     return None
 
 
+@cache.memoize(timeout=300)
+def list_github_files(prefix):
+    """
+    List the paths of files under prefix in the GitHub repository, since raw
+    URLs can't list a directory. Returns an empty list if GitHub can't be reached.
+    """
+    response = requests.get(GITHUB_TREE_URL)
+    if response.status_code != 200:
+        return []
+    return [item["path"] for item in response.json().get("tree", [])
+            if item.get("type") == "blob" and item["path"].startswith(prefix)]
+
+
 def get_local_file_content(root_dir, filename):
     """
     Get content from a local file. Returns content or None if not found.
@@ -111,6 +126,61 @@ def sign_content(content):
     )
     return signature.hex()
 
+def build_manifest():
+    """
+    List every script with the variant subdirectories it is available under
+    (e.g. "linux", "linux/amd64" or "generic"). Extra per-script fields such as
+    a description can be given in scripts/manifest.json, keyed by script name.
+    The manifest has the same {"scripts": [...]} shape whether the scripts come
+    from GitHub or the local scripts directory.
+    """
+    extra = {}
+    files = []
+    if USE_GITHUB:
+        content = fetch_from_github("scripts/manifest.json")
+        if content:
+            extra = json.loads(content)
+        for path in list_github_files("scripts/"):
+            variant, _, filename = path[len("scripts/"):].rpartition('/')
+            files.append((variant or 'generic', filename))
+    else:
+        extra_path = os.path.join(SCRIPTS_DIR, 'manifest.json')
+        if os.path.isfile(extra_path):
+            with open(extra_path, 'r') as f:
+                extra = json.load(f)
+        for root, _, filenames in os.walk(SCRIPTS_DIR):
+            variant = os.path.relpath(root, SCRIPTS_DIR).replace(os.sep, '/')
+            if variant == '.':
+                variant = 'generic'
+            for filename in filenames:
+                files.append((variant, filename))
+
+    scripts = {}
+    for variant, filename in sorted(files):
+        if variant == 'generic' and filename == 'manifest.json':
+            continue
+        entry = scripts.setdefault(filename, {"name": filename, "variants": []})
+        entry["variants"].append(variant)
+
+    for name, fields in extra.items():
+        if name in scripts:
+            scripts[name].update({k: v for k, v in fields.items() if k not in ("name", "variants")})
+
+    return {"scripts": [scripts[name] for name in sorted(scripts)]}
+
+
+def make_script_response(content):
+    """
+    Build a signed script response with an ETag so clients can revalidate
+    their cached copy with If-None-Match and get a 304 Not Modified.
+    """
+    signature_hex = sign_content(content)
+    response = make_response(content)
+    response.headers['Content-Type'] = 'application/octet-stream'
+    response.headers['X-Signature'] = signature_hex
+    response.set_etag(compute_hash(content))
+    return response.make_conditional(request)
+
 def compute_hash(content):
     """
     Compute SHA256 hash of the content.
@@ -135,6 +205,15 @@ def serve_bin(filename):
     return response
 
 
+@app.route('/scripts/manifest.json', methods=['GET'])
+def serve_manifest():
+    content = json.dumps(build_manifest()).encode()
+    response = make_response(content)
+    response.headers['Content-Type'] = 'application/json'
+    response.headers['X-Signature'] = sign_content(content)
+    return response
+
+
 @app.route('/scripts/<path:filename>', methods=['GET'])
 def serve_script_no_os(filename):
     # This route is for scripts without OS directory specification
@@ -142,11 +221,7 @@ def serve_script_no_os(filename):
     if content is None:
         abort(404, description="Script not found")
 
-    signature_hex = sign_content(content)
-    response = make_response(content)
-    response.headers['Content-Type'] = 'application/octet-stream'
-    response.headers['X-Signature'] = signature_hex
-    return response
+    return make_script_response(content)
 
 
 @app.route('/scripts/<os_dir>/<path:filename>', methods=['GET'])
@@ -156,11 +231,7 @@ def serve_script(os_dir, filename):
     if content is None:
         abort(404, description="Script not found")
 
-    signature_hex = sign_content(content)
-    response = make_response(content)
-    response.headers['Content-Type'] = 'application/octet-stream'
-    response.headers['X-Signature'] = signature_hex
-    return response
+    return make_script_response(content)
 
 
 @app.route('/scripts/hash/<path:filename>', methods=['GET'])
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/osquery/osquery-go"
+)
+
+// authTransport adds the configured bearer token and API key headers to every request
+// sent to the script server. Requests to other hosts (e.g. after a redirect) are left alone.
+type authTransport struct {
+	base         http.RoundTripper
+	hosts        map[string]bool
+	bearerToken  string
+	apiKey       string
+	apiKeyHeader string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (t.bearerToken == "" && t.apiKey == "") || !t.hosts[strings.ToLower(req.URL.Host)] {
+		return t.base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	if t.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+t.bearerToken)
+	}
+	if t.apiKey != "" {
+		req.Header.Set(t.apiKeyHeader, t.apiKey)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newAuthTransport wraps base with the auth headers from the scout config
+func newAuthTransport(base http.RoundTripper, config ScoutConfig) (http.RoundTripper, error) {
+	token, err := loadBearerToken(config)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeyHeader := config.APIKeyHeader
+	if apiKeyHeader == "" {
+		apiKeyHeader = "X-API-Key"
+	}
+
+	hosts := make(map[string]bool)
+	for _, serverURL := range config.ServerURLs {
+		if u, err := url.Parse(serverURL); err == nil {
+			hosts[strings.ToLower(u.Host)] = true
+		}
+	}
+
+	return &authTransport{
+		base:         base,
+		hosts:        hosts,
+		bearerToken:  token,
+		apiKey:       config.APIKey,
+		apiKeyHeader: apiKeyHeader,
+	}, nil
+}
+
+// Resolve the bearer token from, in order: the config value, a token file, or osquery's enroll secret
+func loadBearerToken(config ScoutConfig) (string, error) {
+	if config.BearerToken != "" {
+		return config.BearerToken, nil
+	}
+
+	if config.BearerTokenFile != "" {
+		data, err := os.ReadFile(config.BearerTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read bearer_token_file: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	if config.UseEnrollSecret {
+		secret, err := getEnrollSecret()
+		if err != nil {
+			return "", fmt.Errorf("failed to read osquery enroll secret: %v", err)
+		}
+		return secret, nil
+	}
+
+	return "", nil
+}
+
+// Read osquery's enroll secret using the enroll_secret_path or enroll_secret_env flags
+func getEnrollSecret() (string, error) {
+	client, err := osquery.NewClient(socketPath, 5*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("error creating osquery client: %v", err)
+	}
+	defer client.Close()
+
+	rows, err := client.QueryRows("SELECT name, value FROM osquery_flags WHERE name IN ('enroll_secret_path', 'enroll_secret_env');")
+	if err != nil {
+		return "", fmt.Errorf("failed to query osquery_flags: %v", err)
+	}
+
+	for _, row := range rows {
+		if row["value"] == "" {
+			continue
+		}
+		switch row["name"] {
+		case "enroll_secret_path":
+			data, err := os.ReadFile(row["value"])
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(string(data)), nil
+		case "enroll_secret_env":
+			if secret := os.Getenv(row["value"]); secret != "" {
+				return strings.TrimSpace(secret), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("osquery has no enroll secret configured")
+}
+
+// newProxyFunc returns the transport proxy function. Without proxy_url the standard
+// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables apply.
+func newProxyFunc(config ScoutConfig) (func(*http.Request) (*url.URL, error), error) {
+	if config.ProxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(config.ProxyURL)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url %q", config.ProxyURL)
+	}
+
+	noProxy := append([]string{}, config.NoProxy...)
+	for _, env := range []string{"NO_PROXY", "no_proxy"} {
+		for _, entry := range strings.Split(os.Getenv(env), ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				noProxy = append(noProxy, entry)
+			}
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// Check a request URL against NO_PROXY style entries: "*", hostnames and domain suffixes
+// (with or without a leading dot), IPs, CIDR ranges, and any of those with a ":port"
+func bypassProxy(reqURL *url.URL, noProxy []string) bool {
+	host := strings.ToLower(reqURL.Hostname())
+	port := reqURL.Port()
+	if port == "" {
+		port = "80"
+		if reqURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost = strings.TrimPrefix(entryHost, "*")
+		entryHost = strings.TrimPrefix(entryHost, ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
@@ -5,9 +5,9 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -32,22 +32,50 @@ type ExecutionCache struct {
 	Status        string // "pending", "running", "completed", "failed", "timeout"
 }
 
-// CacheMeta is a struct that represents the cache meta file that contains the last time the cache was updated
+// CacheMeta is the metadata stored alongside a cached script, including the last time the cache was updated.
+// The JSON tags match the .meta files of the old file based cache, see migrateFileCache.
 type CacheMeta struct {
 	ScriptHash string    `json:"script_hash"`
 	ScriptName string    `json:"script_name"`
+	Variant    string    `json:"variant"`
+	Server     string    `json:"server"`
 	CacheTime  time.Time `json:"cache_time"`
+
+	// Validators from the script response, used for conditional revalidation
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	LastAccessed time.Time `json:"-"`
+	Hits         int64     `json:"-"`
 }
 
-func loadSignatureFromCache(cacheKey string, cacheDir string) ([]byte, error) {
-	signatureFilePath := getSignatureFilePath(cacheKey, cacheDir)
-	signature, err := os.ReadFile(signatureFilePath)
-	if err != nil {
-		return nil, err
-	}
-	return signature, nil
+// cacheEntry is a full row of the scripts table
+type cacheEntry struct {
+	RowID     int64 // SQLite rowid, used as the osquery rowid in scout_cache
+	CacheKey  string
+	Contents  []byte // Plaintext script, nil if it could not be decrypted
+	Signature []byte
+	Meta      CacheMeta
+
+	// Fingerprint of the public key the signature was verified with when the entry was cached,
+	// see keyFingerprint. Empty for entries from versions that didn't record it.
+	SigningKeyID string
+
+	// Contents as stored in the database and the id of the key that encrypted them, empty for plaintext
+	StoredContents []byte
+	KeyID          string
+	DecryptErr     error // Set by listCachedScripts when Contents could not be decrypted
 }
 
+const cacheDBName = "scout_cache.db"
+
+var (
+	cacheDB     *sql.DB // Shared handle to scout_cache.db, see openCacheDB
+	cacheDBPath string
+
+	errCacheMiss = errors.New("script is not cached")
+)
+
 // Determine the OS-specific subdirectory based on runtime.GOOS
 func getOSSubDir() string {
 	switch runtime.GOOS {
@@ -72,122 +100,210 @@ func getScriptURL(serverURL, scriptName string) string {
 		strings.TrimRight(serverURL, "/"), osDir, url.PathEscape(scriptName))
 }
 
-// Helper function to get signature file path
-func getSignatureFilePath(cacheKey string, cacheDir string) string {
-
-	return filepath.Join(cacheDir, fmt.Sprintf("%s.script.sig", cacheKey))
+// Build the URL for a script under a specific variant subdirectory (see getScriptVariants)
+func getVariantURL(serverURL, variant, scriptName string) string {
+	if variant == "" || variant == genericVariant {
+		return fmt.Sprintf("%s/%s", strings.TrimRight(serverURL, "/"), url.PathEscape(scriptName))
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.TrimRight(serverURL, "/"), variant, url.PathEscape(scriptName))
 }
 
-// Helper function to load script from cache
-func loadScriptFromCache(cacheKey string, cacheDir string) ([]byte, CacheMeta, error) {
-	cacheFilePath := getCacheFilePath(cacheKey, cacheDir)
-
-	//log.Printf(" %s", cacheFilePath)
-	//check if the cache file exists
-	if _, err := os.Stat(cacheFilePath); os.IsNotExist(err) {
-		return nil, CacheMeta{}, fmt.Errorf("cache file does not exist")
+// Helper function to load script, signature and metadata from the cache database.
+// A script that isn't cached returns errCacheMiss, contents that can't be decrypted are
+// reported in DecryptErr.
+func loadScriptFromCache(cacheKey string) (cacheEntry, error) {
+	entry := cacheEntry{CacheKey: cacheKey}
+	var cacheTime, lastAccessed int64
+
+	row := cacheDB.QueryRow(`SELECT script_name, contents, encryption_key_id, signature, signing_key_id, script_hash, variant,
+		server, etag, last_modified, cache_time, last_accessed, hit_count FROM scripts WHERE cache_key = ?`, cacheKey)
+	err := row.Scan(&entry.Meta.ScriptName, &entry.StoredContents, &entry.KeyID, &entry.Signature, &entry.SigningKeyID, &entry.Meta.ScriptHash,
+		&entry.Meta.Variant, &entry.Meta.Server, &entry.Meta.ETag, &entry.Meta.LastModified, &cacheTime, &lastAccessed,
+		&entry.Meta.Hits)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entry, errCacheMiss
 	}
-
-	data, err := os.ReadFile(cacheFilePath)
 	if err != nil {
-		return nil, CacheMeta{}, err
+		return entry, err
 	}
+	entry.Meta.CacheTime = time.Unix(0, cacheTime)
+	entry.Meta.LastAccessed = time.Unix(0, lastAccessed)
+
+	entry.Contents, entry.DecryptErr = openCacheContents(cacheKey, entry.StoredContents, entry.KeyID)
+	return entry, nil
+}
 
-	// Read metadata file
-	metadataFilePath := cacheFilePath + ".meta"
-	metadataData, err := os.ReadFile(metadataFilePath)
+// loadVerifiedScriptFromCache loads a cached script and checks its stored signature and hash.
+// A script that isn't cached returns errCacheMiss, one that fails the check a *cacheIntegrityError.
+func loadVerifiedScriptFromCache(cacheKey string, publicKeyStr string) (*Script, CacheMeta, error) {
+	entry, err := loadScriptFromCache(cacheKey)
 	if err != nil {
 		return nil, CacheMeta{}, err
 	}
+	scriptMeta := entry.Meta
 
-	var metadata struct {
-		ScriptHash string    `json:"script_hash"`
-		ScriptName string    `json:"script_name"`
-		CacheTime  time.Time `json:"cache_time"`
-	}
-	err = json.Unmarshal(metadataData, &metadata)
-	if err != nil {
-		return nil, CacheMeta{}, err
+	result := checkCacheEntryIntegrity(entry, publicKeyStr)
+	if !result.ok() {
+		return nil, CacheMeta{}, &cacheIntegrityError{entry, result}
 	}
+	scriptHash := result.ComputedHash
+
+	return &Script{
+		Name:     scriptMeta.ScriptName,
+		Contents: entry.Contents,
+		Hash:     scriptHash,
+		Cached:   true,
+		Variant:  scriptMeta.Variant,
+		Server:   scriptMeta.Server,
+		CachedAt: scriptMeta.CacheTime,
+	}, scriptMeta, nil
+}
 
-	return data, metadata, nil
+// Helper function to save a script with its signature and metadata, replacing any existing entry
+func saveScriptToCache(cacheKey string, script Script, signature []byte, signingKeyID string, etag string, lastModified string) error {
+	metadata := CacheMeta{
+		ScriptHash:   script.Hash,
+		ScriptName:   script.Name,
+		Variant:      script.Variant,
+		Server:       script.Server,
+		CacheTime:    time.Now(),
+		ETag:         etag,
+		LastModified: lastModified,
+	}
+	return upsertCacheEntry(cacheDB, cacheEntry{
+		CacheKey:     cacheKey,
+		Contents:     script.Contents,
+		Signature:    signature,
+		SigningKeyID: signingKeyID,
+		Meta:         metadata,
+	})
 }
 
-func saveScriptToCache(cacheKey string, script Script, signature []byte, cacheDir string) error {
+// Insert or replace a cache entry in a single statement so the script, signature and
+// metadata are always updated together
+func upsertCacheEntry(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, entry cacheEntry) error {
+	stored, keyID, err := sealCacheContents(entry.CacheKey, entry.Contents)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt script: %v", err)
+	}
 
-	scriptHash := script.Hash
-	scriptName := script.Name
-	data := script.Contents
+	now := time.Now().UnixNano()
+	_, err = db.Exec(`INSERT INTO scripts (cache_key, script_name, contents, encryption_key_id, signature, signing_key_id,
+			script_hash, variant, server, etag, last_modified, cache_time, last_accessed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(cache_key) DO UPDATE SET
+			script_name = excluded.script_name,
+			contents = excluded.contents,
+			encryption_key_id = excluded.encryption_key_id,
+			signature = excluded.signature,
+			signing_key_id = excluded.signing_key_id,
+			script_hash = excluded.script_hash,
+			variant = excluded.variant,
+			server = excluded.server,
+			etag = excluded.etag,
+			last_modified = excluded.last_modified,
+			cache_time = excluded.cache_time,
+			last_accessed = excluded.last_accessed`,
+		entry.CacheKey, entry.Meta.ScriptName, stored, keyID, entry.Signature, entry.SigningKeyID, entry.Meta.ScriptHash,
+		entry.Meta.Variant, entry.Meta.Server, entry.Meta.ETag, entry.Meta.LastModified,
+		entry.Meta.CacheTime.UnixNano(), now)
+	return err
+}
 
-	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
-		err = os.MkdirAll(cacheDir, 0755)
-		if err != nil {
-			return err
-		}
-	}
+// Helper function to restart the cache window of an entry once the server has confirmed it is current
+func touchCacheEntry(cacheKey string, cacheTime time.Time) error {
+	_, err := cacheDB.Exec(`UPDATE scripts SET cache_time = ? WHERE cache_key = ?`, cacheTime.UnixNano(), cacheKey)
+	return err
+}
 
-	cacheFilePath := getCacheFilePath(cacheKey, cacheDir)
-	if _, err := os.Stat(cacheFilePath); err == nil {
-		err = os.Remove(cacheFilePath)
-		if err != nil {
-			return err
-		}
-	}
-	err := os.WriteFile(cacheFilePath, data, 0600)
+// Helper function to record that a cached copy was served, for LRU eviction and the hits column
+func recordCacheHit(cacheKey string) {
+	_, err := cacheDB.Exec(`UPDATE scripts SET last_accessed = ?, hit_count = hit_count + 1 WHERE cache_key = ?`,
+		time.Now().UnixNano(), cacheKey)
 	if err != nil {
-		return err
+		log.Printf("Failed to record cache hit: %v\n", err)
 	}
+}
 
-	metadata := CacheMeta{
-		ScriptHash: scriptHash,
-		ScriptName: scriptName,
-		CacheTime:  time.Now(),
+// Helper function to remove a script and its signature from the cache
+func removeScriptFromCache(cacheKey string) {
+	if _, err := cacheDB.Exec(`DELETE FROM scripts WHERE cache_key = ?`, cacheKey); err != nil {
+		log.Printf("Failed to remove script from cache: %v\n", err)
 	}
-	metadataData, err := json.Marshal(metadata)
+}
+
+// Helper function to remove a cache entry by its SQLite rowid, for DELETE on scout_cache
+func removeCacheEntryByRowID(rowID int64) (bool, error) {
+	result, err := cacheDB.Exec(`DELETE FROM scripts WHERE rowid = ?`, rowID)
 	if err != nil {
-		return err
+		return false, err
 	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
 
-	if _, err := os.Stat(cacheFilePath + ".meta"); err == nil {
-		err = os.Remove(cacheFilePath + ".meta")
-		if err != nil {
-			return err
-		}
+// Helper function to remove every cached script
+func purgeCache() (int64, error) {
+	result, err := cacheDB.Exec(`DELETE FROM scripts`)
+	if err != nil {
+		return 0, err
 	}
+	return result.RowsAffected()
+}
 
-	metadataFilePath := cacheFilePath + ".meta"
-	err = os.WriteFile(metadataFilePath, metadataData, 0600)
+// Helper function to move a tampered entry out of the scripts table into quarantine, keeping
+// its contents for investigation
+func quarantineCacheEntry(entry cacheEntry, reason string) error {
+	tx, err := cacheDB.Begin()
 	if err != nil {
 		return err
 	}
-
-	if _, err := os.Stat(getSignatureFilePath(cacheKey, cacheDir)); err == nil {
-		err = os.Remove(getSignatureFilePath(cacheKey, cacheDir))
-		if err != nil {
-			return err
-		}
+	defer tx.Rollback()
+
+	// Keep the contents as stored so encrypted scripts stay encrypted in quarantine
+	_, err = tx.Exec(`INSERT INTO quarantine (cache_key, script_name, contents, encryption_key_id, signature, signing_key_id,
+			script_hash, reason, quarantined_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.CacheKey, entry.Meta.ScriptName, entry.StoredContents, entry.KeyID, entry.Signature, entry.SigningKeyID, entry.Meta.ScriptHash,
+		reason, time.Now().UnixNano())
+	if err != nil {
+		return err
 	}
-	signatureFilePath := cacheFilePath + ".sig"
-	// Save the signature to cache
-	err = os.WriteFile(signatureFilePath, signature, 0600)
+	_, err = tx.Exec(`DELETE FROM scripts WHERE cache_key = ?`, entry.CacheKey)
 	if err != nil {
 		return err
 	}
-
-	return nil
-}
-
-// Modified removeScriptFromCache to remove signature file
-func removeScriptFromCache(cacheKey string, cacheDir string) {
-	cacheFilePath := getCacheFilePath(cacheKey, cacheDir)
-	os.Remove(cacheFilePath)
-	os.Remove(cacheFilePath + ".meta")
-	os.Remove(getSignatureFilePath(cacheKey, cacheDir))
+	return tx.Commit()
 }
 
-// Helper function to get cache file path based on cache key
-func getCacheFilePath(cacheKey string, cacheDir string) string {
-	return filepath.Join(cacheDir, fmt.Sprintf("%s.script", cacheKey))
+// Helper function to list every cached script for the scout_cache table
+func listCachedScripts() ([]cacheEntry, error) {
+	rows, err := cacheDB.Query(`SELECT rowid, cache_key, script_name, contents, encryption_key_id, signature, signing_key_id,
+		script_hash, variant, server, etag, last_modified, cache_time, last_accessed, hit_count FROM scripts ORDER BY script_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []cacheEntry
+	for rows.Next() {
+		var entry cacheEntry
+		var cacheTime, lastAccessed int64
+		err := rows.Scan(&entry.RowID, &entry.CacheKey, &entry.Meta.ScriptName, &entry.StoredContents, &entry.KeyID,
+			&entry.Signature, &entry.SigningKeyID, &entry.Meta.ScriptHash, &entry.Meta.Variant, &entry.Meta.Server, &entry.Meta.ETag, &entry.Meta.LastModified, &cacheTime, &lastAccessed,
+			&entry.Meta.Hits)
+		if err != nil {
+			return nil, err
+		}
+		entry.Meta.CacheTime = time.Unix(0, cacheTime)
+		entry.Meta.LastAccessed = time.Unix(0, lastAccessed)
+		// One unreadable entry shouldn't hide the rest, the integrity checks report it
+		entry.Contents, entry.DecryptErr = openCacheContents(entry.CacheKey, entry.StoredContents, entry.KeyID)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
 }
 
 // Helper function to get cache key based on URL
@@ -197,53 +313,95 @@ func getCacheKey(url string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-// Helper function to get script hash from server
-func getScriptHashFromServer(serverURL, scriptName string) (string, error) {
-	hashURL := fmt.Sprintf("%s/hash/%s", strings.TrimRight(serverURL, "/"), url.PathEscape(scriptName))
-	resp, err := http.Get(hashURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch script hash: %v", err)
-	}
-	defer resp.Body.Close()
+// openCacheDB opens (creating if needed) the cache database in cacheDir, makes sure the
+// schema exists and moves any scripts left by the old file based cache into it. This runs
+// under the cache directory lock so concurrent extension processes don't migrate twice.
+func openCacheDB(cacheDir string) error {
+	return withCacheLock(cacheDir, func() error {
+		if err := recoverCacheDir(cacheDir); err != nil {
+			return fmt.Errorf("failed to recover cache directory: %v", err)
+		}
+
+		dbPath := filepath.Join(cacheDir, cacheDBName)
+		db, err := openCacheDBFile(dbPath)
+		if err != nil {
+			return err
+		}
+
+		if err := createCacheDB(db); err != nil {
+			db.Close()
+			return err
+		}
+
+		cacheDB = db
+		cacheDBPath = dbPath
+
+		if err := migrateFileCache(cacheDir); err != nil {
+			return fmt.Errorf("failed to migrate file cache: %v", err)
+		}
+		if err := reconcileCacheEncryption(); err != nil {
+			return fmt.Errorf("failed to update cache encryption: %v", err)
+		}
+		if err := reconcileSigningKey(currentConfig().PublicKey); err != nil {
+			return fmt.Errorf("failed to check cache signing keys: %v", err)
+		}
+		return nil
+	})
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch script hash: received status code %d", resp.StatusCode)
+// Open the database file, starting over with an empty one if the existing file is corrupt
+func openCacheDBFile(dbPath string) (*sql.DB, error) {
+	dsn := "file:" + dbPath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err == nil {
+		err = checkCacheDB(db)
+		if err == nil {
+			return db, nil
+		}
+		db.Close()
+	}
+	if !isCorruptCacheDB(err) {
+		return nil, err
 	}
 
-	var result struct {
-		ScriptHash string `json:"script_hash"`
+	log.Printf("Cache database failed consistency check: %v\n", err)
+	if err := setAsideCorruptCacheDB(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to move corrupt cache database: %v", err)
 	}
-	body, err := io.ReadAll(resp.Body)
+	db, err = sql.Open("sqlite", dsn)
 	if err != nil {
-		return "", fmt.Errorf("failed to read script hash response: %v", err)
+		return nil, err
 	}
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse script hash JSON: %v", err)
+	if err := checkCacheDB(db); err != nil {
+		db.Close()
+		return nil, err
 	}
-
-	return result.ScriptHash, nil
+	return db, nil
 }
 
-// Helper function to create a sqlite3 database for caching, user passes in the path to the database
-func createCacheDB(dbPath string) error {
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		file, err := os.Create(dbPath)
-		if err != nil {
-			return err
-		}
-		file.Close()
-	}
+// Only corruption is worth discarding the cache for, not e.g. a permission error
+func isCorruptCacheDB(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "quick_check") || strings.Contains(msg, "not a database") ||
+		strings.Contains(msg, "malformed")
+}
 
-	db, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
+// Helper function to run SQLite's quick consistency check on the cache database
+func checkCacheDB(db *sql.DB) error {
+	var result string
+	if err := db.QueryRow(`PRAGMA quick_check`).Scan(&result); err != nil {
 		return err
 	}
+	if result != "ok" {
+		return fmt.Errorf("quick_check: %s", result)
+	}
+	return nil
+}
 
-	defer db.Close()
-
+// Helper function to create the cache tables
+func createCacheDB(db *sql.DB) error {
 	//create teh execution cache table based on the ExecutionCache struct
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS execution_cache (
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS execution_cache (
 		job_id TEXT PRIMARY KEY,
 		script TEXT,
 		args TEXT,
@@ -256,10 +414,174 @@ func createCacheDB(dbPath string) error {
 		cache_enabled TEXT,
 		status TEXT
 	)`)
+	if err != nil {
+		return err
+	}
+
+	// Cached scripts with their signature and metadata, times are unix nanoseconds
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS scripts (
+		cache_key TEXT PRIMARY KEY,
+		script_name TEXT NOT NULL,
+		contents BLOB NOT NULL,
+		signature BLOB NOT NULL,
+		script_hash TEXT NOT NULL,
+		variant TEXT NOT NULL DEFAULT '',
+		server TEXT NOT NULL DEFAULT '',
+		etag TEXT NOT NULL DEFAULT '',
+		last_modified TEXT NOT NULL DEFAULT '',
+		cache_time INTEGER NOT NULL,
+		last_accessed INTEGER NOT NULL,
+		hit_count INTEGER NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// Cache entries pulled by the integrity scan because they no longer match their hash or signature
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS quarantine (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		cache_key TEXT NOT NULL,
+		script_name TEXT NOT NULL,
+		contents BLOB,
+		signature BLOB,
+		script_hash TEXT,
+		reason TEXT NOT NULL,
+		quarantined_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the scripts table was first released
+	if err := addColumnIfMissing(db, "scripts", "hit_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "scripts", "encryption_key_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "quarantine", "encryption_key_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "scripts", "signing_key_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "quarantine", "signing_key_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "execution_cache", "expires_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "execution_cache", "encryption_key_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Helper function to add a column to an existing table when upgrading an older cache database
+func addColumnIfMissing(db *sql.DB, tableName, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", tableName))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, column, definition))
+	return err
+}
+
+// migrateFileCache imports the <key>.script, <key>.script.meta and signature files written by
+// earlier versions into the cache database and removes them. Entries missing their metadata or
+// signature can't be trusted and are dropped.
+func migrateFileCache(cacheDir string) error {
+	files, err := filepath.Glob(filepath.Join(cacheDir, "*.script"))
 	if err != nil {
 		return err
 	}
 
+	for _, scriptPath := range files {
+		cacheKey := strings.TrimSuffix(filepath.Base(scriptPath), ".script")
+		metaPath := scriptPath + ".meta"
+		// Older versions wrote the signature to <key>.script.sig, but <key>.sig may also exist
+		sigPaths := []string{scriptPath + ".sig", filepath.Join(cacheDir, cacheKey+".sig")}
+
+		entry, err := readFileCacheEntry(cacheKey, scriptPath, metaPath, sigPaths)
+		if err != nil {
+			log.Printf("Dropping unusable file cache entry %s: %v\n", cacheKey, err)
+		} else {
+			tx, err := cacheDB.Begin()
+			if err != nil {
+				return err
+			}
+			// Don't clobber an entry the database already has
+			var exists int
+			err = tx.QueryRow(`SELECT COUNT(*) FROM scripts WHERE cache_key = ?`, cacheKey).Scan(&exists)
+			if err == nil && exists == 0 {
+				err = upsertCacheEntry(tx, entry)
+			}
+			if err == nil {
+				err = tx.Commit()
+			} else {
+				tx.Rollback()
+			}
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %v", cacheKey, err)
+			}
+			log.Printf("Migrated cached script %s into %s\n", entry.Meta.ScriptName, cacheDBName)
+		}
+
+		os.Remove(scriptPath)
+		os.Remove(metaPath)
+		for _, sigPath := range sigPaths {
+			os.Remove(sigPath)
+		}
+	}
+
 	return nil
 }
+
+func readFileCacheEntry(cacheKey, scriptPath, metaPath string, sigPaths []string) (cacheEntry, error) {
+	entry := cacheEntry{CacheKey: cacheKey}
+
+	contents, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return entry, err
+	}
+	entry.Contents = contents
+
+	metaData, err := os.ReadFile(metaPath)
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(metaData, &entry.Meta); err != nil {
+		return entry, err
+	}
+
+	for _, sigPath := range sigPaths {
+		if signature, err := os.ReadFile(sigPath); err == nil {
+			entry.Signature = signature
+			break
+		}
+	}
+	if entry.Signature == nil {
+		return entry, fmt.Errorf("no signature file")
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/osquery/osquery-go"
+)
+
+// Where the cache encryption key comes from
+const (
+	cacheKeySourceFile    = "file"    // A random secret in cache_key_file, generated on first use
+	cacheKeySourceOsquery = "osquery" // The host's system UUID and osquery instance id
+)
+
+const cacheKeyFileName = "scout_cache.key"
+
+// Domain separation for the key derivation, bump the version if the scheme changes
+const cacheKeyContext = "osquery-scout cache encryption v1"
+
+var (
+	cacheCipher cipher.AEAD // AEAD for cached script contents, nil when encrypt_cache is off
+	cacheKeyID  string      // Fingerprint of the key, stored with each entry it encrypted
+
+	errCacheKeyUnavailable = errors.New("cached script was encrypted with a different key")
+)
+
+// initCacheEncryption loads the host-bound secret and derives the AES-256-GCM key used to
+// encrypt cached script contents. Must run before the cache database is opened.
+func initCacheEncryption(config ScoutConfig) error {
+	if !config.EncryptCache {
+		return nil
+	}
+
+	var secret []byte
+	var err error
+	switch config.CacheKeySource {
+	case cacheKeySourceOsquery:
+		secret, err = getOsqueryHostSecret()
+	default:
+		if pathInsideDir(config.CacheDir, config.CacheKeyFile) {
+			log.Printf("Warning: cache key file %s is inside cache_dir, anyone with a copy of the cache can decrypt it\n", config.CacheKeyFile)
+		}
+		// Two extensions starting together must not both generate a key
+		err = withCacheLock(config.CacheDir, func() error {
+			secret, err = loadCacheKeyFile(config.CacheKeyFile)
+			return err
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load cache encryption key: %v", err)
+	}
+
+	// HMAC-SHA256 as a KDF turns a secret of any length into a 256-bit key
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(cacheKeyContext))
+	key := mac.Sum(nil)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	cacheCipher = aead
+	fingerprint := sha256.Sum256(key)
+	cacheKeyID = hex.EncodeToString(fingerprint[:8])
+	log.Printf("Cache encryption enabled using %s key\n", config.CacheKeySource)
+	return nil
+}
+
+// Helper function to check whether path is dir or somewhere below it
+func pathInsideDir(dir string, path string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Read the secret from keyPath, generating a random one readable only by us if it doesn't exist
+func loadCacheKeyFile(keyPath string) ([]byte, error) {
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(secret) < 16 {
+			return nil, fmt.Errorf("%s must contain at least 16 hex encoded bytes", keyPath)
+		}
+		if info, err := os.Stat(keyPath); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+			log.Printf("Warning: cache key file %s is accessible by other users (mode %s)\n", keyPath, info.Mode().Perm())
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	// Written in one step so a crash can't leave a truncated key behind
+	if err := writeFileAtomic(keyPath, []byte(hex.EncodeToString(secret)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated cache encryption key %s\n", keyPath)
+	return secret, nil
+}
+
+// Build a host-bound secret from the hardware UUID and the osquery instance id, which is
+// stored in osquery's own database and differs between hosts even with cloned hardware ids
+func getOsqueryHostSecret() ([]byte, error) {
+	client, err := osquery.NewClient(socketPath, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error creating osquery client: %v", err)
+	}
+	defer client.Close()
+
+	rows, err := client.QueryRows("SELECT s.uuid, i.instance_id FROM system_info s, osquery_info i;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query host identifiers: %v", err)
+	}
+	if len(rows) == 0 || rows[0]["uuid"] == "" || rows[0]["instance_id"] == "" {
+		return nil, fmt.Errorf("osquery did not report a host uuid and instance id")
+	}
+	return []byte(rows[0]["uuid"] + "/" + rows[0]["instance_id"]), nil
+}
+
+// sealCacheContents encrypts script contents for storage when encryption is enabled. The cache
+// key is bound as additional data so ciphertext can't be moved to another entry. Returns the
+// bytes to store and the id of the key used, empty for plaintext.
+func sealCacheContents(cacheKey string, contents []byte) ([]byte, string, error) {
+	if cacheCipher == nil {
+		return contents, "", nil
+	}
+	nonce := make([]byte, cacheCipher.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, "", err
+	}
+	return cacheCipher.Seal(nonce, nonce, contents, []byte(cacheKey)), cacheKeyID, nil
+}
+
+// openCacheContents reverses sealCacheContents. Entries encrypted under another key return
+// errCacheKeyUnavailable; failing to decrypt with the current key means the entry was
+// tampered with.
+func openCacheContents(cacheKey string, stored []byte, keyID string) ([]byte, error) {
+	if keyID == "" {
+		return stored, nil
+	}
+	if cacheCipher == nil || keyID != cacheKeyID {
+		return nil, errCacheKeyUnavailable
+	}
+	nonceSize := cacheCipher.NonceSize()
+	if len(stored) < nonceSize {
+		return nil, fmt.Errorf("encrypted cache entry is truncated")
+	}
+	contents, err := cacheCipher.Open(nil, stored[:nonceSize], stored[nonceSize:], []byte(cacheKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt cached script: %v", err)
+	}
+	return contents, nil
+}
+
+// reconcileCacheEncryption brings existing entries in line with the current settings: entries
+// encrypted under another key (or with encryption since turned off) can't be read and are
+// dropped to be downloaded again, and plaintext entries are encrypted when encryption is on
+func reconcileCacheEncryption() error {
+	result, err := cacheDB.Exec(`DELETE FROM scripts WHERE encryption_key_id != '' AND encryption_key_id != ?`, cacheKeyID)
+	if err != nil {
+		return err
+	}
+	if dropped, _ := result.RowsAffected(); dropped > 0 {
+		log.Printf("Dropped %d cached scripts encrypted with a key that is no longer configured\n", dropped)
+	}
+
+	if cacheCipher == nil {
+		return nil
+	}
+
+	rows, err := cacheDB.Query(`SELECT cache_key, contents FROM scripts WHERE encryption_key_id = ''`)
+	if err != nil {
+		return err
+	}
+	plain := make(map[string][]byte)
+	for rows.Next() {
+		var cacheKey string
+		var contents []byte
+		if err := rows.Scan(&cacheKey, &contents); err != nil {
+			rows.Close()
+			return err
+		}
+		plain[cacheKey] = contents
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for cacheKey, contents := range plain {
+		sealed, keyID, err := sealCacheContents(cacheKey, contents)
+		if err != nil {
+			return err
+		}
+		_, err = cacheDB.Exec(`UPDATE scripts SET contents = ?, encryption_key_id = ? WHERE cache_key = ? AND encryption_key_id = ''`,
+			sealed, keyID, cacheKey)
+		if err != nil {
+			return err
+		}
+	}
+	if len(plain) > 0 {
+		log.Printf("Encrypted %d cached scripts\n", len(plain))
+	}
+	return nil
+}
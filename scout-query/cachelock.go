@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const cacheLockName = "scout_cache.lock"
+
+// Suffix of the temporary files written by writeFileAtomic, so leftovers can be found at startup
+const atomicTempSuffix = ".scout-tmp"
+
+// withCacheLock runs fn while holding an exclusive lock on the cache directory, serializing
+// cache maintenance between extension processes that share cache_dir. SQLite already
+// serializes individual writes; this covers multi-step work such as migration and eviction.
+func withCacheLock(cacheDir string, fn func() error) error {
+	lockPath := filepath.Join(cacheDir, cacheLockName)
+	lockFileHandle, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open cache lock: %v", err)
+	}
+	defer lockFileHandle.Close()
+
+	if err := lockFile(lockFileHandle); err != nil {
+		return fmt.Errorf("failed to lock cache directory: %v", err)
+	}
+	defer unlockFile(lockFileHandle)
+
+	return fn()
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place,
+// so readers (and a crash) only ever see the old contents or the complete new ones
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+atomicTempSuffix)
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath) // No-op once the rename has succeeded
+
+	if err := tmpFile.Chmod(perm); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// recoverCacheDir cleans up after a process that died mid-write: temporary files from
+// writeFileAtomic and legacy .meta/.sig files whose script is gone. Must hold the cache lock.
+func recoverCacheDir(cacheDir string) error {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(cacheDir, name)
+
+		if strings.HasSuffix(name, atomicTempSuffix) {
+			log.Printf("Removing incomplete cache file %s\n", name)
+			os.Remove(path)
+			continue
+		}
+
+		// Legacy file cache: <key>.script with <key>.script.meta, <key>.script.sig or <key>.sig
+		var scriptPath string
+		switch {
+		case strings.HasSuffix(name, ".script.meta"), strings.HasSuffix(name, ".script.sig"):
+			scriptPath = filepath.Join(cacheDir, strings.TrimSuffix(strings.TrimSuffix(name, ".meta"), ".sig"))
+		case strings.HasSuffix(name, ".sig"):
+			scriptPath = filepath.Join(cacheDir, strings.TrimSuffix(name, ".sig")+".script")
+		default:
+			continue
+		}
+		if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+			log.Printf("Removing orphaned cache file %s\n", name)
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// Move a cache database that fails SQLite's consistency check aside, along with its WAL
+// files, so a fresh one can be created. The old file is kept for investigation.
+func setAsideCorruptCacheDB(dbPath string) error {
+	suffix := ".corrupt-" + time.Now().UTC().Format("20060102T150405")
+	if err := os.Rename(dbPath, dbPath+suffix); err != nil {
+		return err
+	}
+	for _, ext := range []string{"-wal", "-shm"} {
+		if _, err := os.Stat(dbPath + ext); err == nil {
+			os.Rename(dbPath+ext, dbPath+suffix+ext)
+		}
+	}
+	log.Printf("Cache database %s is corrupt, moved to %s\n", dbPath, dbPath+suffix)
+	return nil
+}
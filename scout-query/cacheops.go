@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// Cache maintenance actions accepted by scout_cache_ops
+const (
+	cacheOpRefresh  = "refresh"   // Re-download and verify the named scripts, or every cached script
+	cacheOpPurgeAll = "purge_all" // Remove every cached script
+	cacheOpVerify   = "verify"    // Run the integrity scan now, quarantining tampered entries
+)
+
+// Cache operations mutate endpoint state, so they are off unless allow_cache_ops is set
+func cacheOpsAllowed() bool {
+	return currentConfig().AllowCacheOps
+}
+
+// ScoutScriptCacheDelete handles DELETE FROM scout_cache, removing the entry with the given rowid
+func ScoutScriptCacheDelete(ctx context.Context, rowID string) error {
+	id, err := strconv.ParseInt(rowID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid rowid %q", rowID)
+	}
+	if id < 0 {
+		return fmt.Errorf("script is not cached")
+	}
+
+	removed, err := removeCacheEntryByRowID(id)
+	if err != nil {
+		return fmt.Errorf("failed to remove cache entry: %v", err)
+	}
+	if !removed {
+		return fmt.Errorf("cache entry not found")
+	}
+	log.Printf("Removed cache entry %d via scout_cache\n", id)
+	return nil
+}
+
+// ScoutCacheOpsGenerate runs the maintenance action given in the action constraint, e.g.
+//
+//	SELECT * FROM scout_cache_ops WHERE action = 'refresh' AND name = 'users.sh';
+func ScoutCacheOpsGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	if !cacheOpsAllowed() {
+		return nil, fmt.Errorf("cache operations are disabled, set allow_cache_ops in the scout config to enable them")
+	}
+
+	actions := processContextConstraints(queryContext, "action")
+	if len(actions) != 1 {
+		return nil, fmt.Errorf("exactly one action must be specified: %s, %s or %s", cacheOpRefresh, cacheOpPurgeAll, cacheOpVerify)
+	}
+	action := actions[0]
+	names := processContextConstraints(queryContext, "name")
+
+	switch action {
+	case cacheOpRefresh:
+		return refreshCachedScripts(names)
+
+	case cacheOpPurgeAll:
+		removed, err := purgeCache()
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge cache: %v", err)
+		}
+		log.Printf("Purged %d cached scripts via scout_cache_ops\n", removed)
+		return []map[string]string{{
+			"action":  action,
+			"status":  "success",
+			"message": fmt.Sprintf("removed %d cached scripts", removed),
+		}}, nil
+
+	case cacheOpVerify:
+		scanned, quarantined := runIntegrityScan()
+		return []map[string]string{{
+			"action":  action,
+			"status":  "success",
+			"message": fmt.Sprintf("checked %d cached scripts, quarantined %d", scanned, quarantined),
+		}}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+}
+
+// Re-download the named scripts, or every cached script when no names are given
+func refreshCachedScripts(names []string) ([]map[string]string, error) {
+	if len(names) == 0 {
+		entries, err := listCachedScripts()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache database: %v", err)
+		}
+		for _, entry := range entries {
+			names = append(names, entry.Meta.ScriptName)
+		}
+	}
+
+	var results []map[string]string
+	for _, name := range names {
+		row := map[string]string{
+			"action": cacheOpRefresh,
+			"name":   name,
+		}
+		// Skipping the cached copy forces a full download, which replaces the entry
+		script, err := getScript(name, false)
+		if err != nil {
+			row["status"] = "failed"
+			row["message"] = err.Error()
+		} else {
+			row["status"] = "success"
+			row["message"] = fmt.Sprintf("cached %s from %s", script.Hash, script.Server)
+		}
+		results = append(results, row)
+	}
+	return results, nil
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deprecated spellings of config keys, mapped to the key they stand for. Older versions of the
+// README documented these names.
+var configAliases = map[string]string{
+	"server_url":   "script_server_url",
+	"cache_window": "cache_window_seconds",
+	"exec_timeout": "exec_timeout_seconds",
+}
+
+// configErrors collects every problem found in the scout section so they can be reported together
+type configErrors []string
+
+func (e configErrors) Error() string {
+	if len(e) == 1 {
+		return "invalid scout config: " + e[0]
+	}
+	return fmt.Sprintf("invalid scout config (%d problems):\n  - %s", len(e), strings.Join(e, "\n  - "))
+}
+
+// configReader reads typed values out of the scout section, recording type errors and
+// which keys were read so anything left over can be reported as unknown
+type configReader struct {
+	options map[string]interface{}
+	read    map[string]bool
+	errs    configErrors
+}
+
+func newConfigReader(options map[string]interface{}) *configReader {
+	r := &configReader{
+		options: make(map[string]interface{}, len(options)),
+		read:    make(map[string]bool),
+	}
+	for key, value := range options {
+		r.options[key] = value
+	}
+
+	// Sorted so warnings and errors come out in a stable order
+	aliases := make([]string, 0, len(configAliases))
+	for alias := range configAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		value, ok := r.options[alias]
+		if !ok {
+			continue
+		}
+		key := configAliases[alias]
+		delete(r.options, alias)
+		if _, ok := r.options[key]; ok {
+			r.errorf("'%s' and its deprecated alias '%s' are both set", key, alias)
+			continue
+		}
+		log.Printf("Warning: scout config key '%s' is deprecated, use '%s'\n", alias, key)
+		r.options[key] = value
+	}
+	return r
+}
+
+func (r *configReader) errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+// lookup returns the raw value of a key and marks it as known. JSON null counts as unset.
+func (r *configReader) lookup(key string) (interface{}, bool) {
+	r.read[key] = true
+	value, ok := r.options[key]
+	return value, ok && value != nil
+}
+
+func (r *configReader) str(key string) string {
+	value, ok := r.lookup(key)
+	if !ok {
+		return ""
+	}
+	s, ok := value.(string)
+	if !ok {
+		r.errorf("'%s' must be a string", key)
+	}
+	return s
+}
+
+func (r *configReader) boolean(key string) bool {
+	value, ok := r.lookup(key)
+	if !ok {
+		return false
+	}
+	b, ok := value.(bool)
+	if !ok {
+		r.errorf("'%s' must be true or false", key)
+	}
+	return b
+}
+
+// number reads a non-negative number, returning def when the key is unset
+func (r *configReader) number(key string, def float64) float64 {
+	value, ok := r.lookup(key)
+	if !ok {
+		return def
+	}
+	n, ok := value.(float64)
+	if !ok {
+		r.errorf("'%s' must be a number", key)
+		return def
+	}
+	if n < 0 || math.IsInf(n, 0) {
+		r.errorf("'%s' must not be negative", key)
+		return def
+	}
+	return n
+}
+
+func (r *configReader) integer(key string, def int64) int64 {
+	n := r.number(key, float64(def))
+	if n != math.Trunc(n) {
+		r.errorf("'%s' must be a whole number", key)
+	}
+	return int64(n)
+}
+
+// duration reads a number of units (e.g. seconds) as a time.Duration
+func (r *configReader) duration(key string, def time.Duration, unit time.Duration) time.Duration {
+	n := r.number(key, float64(def)/float64(unit))
+	return time.Duration(n * float64(unit))
+}
+
+func (r *configReader) stringList(key string) []string {
+	value, ok := r.lookup(key)
+	if !ok {
+		return nil
+	}
+	switch v := value.(type) {
+	case string:
+	case []interface{}:
+		for _, item := range v {
+			if _, ok := item.(string); !ok {
+				r.errorf("'%s' must be a string or a list of strings", key)
+				return nil
+			}
+		}
+	default:
+		r.errorf("'%s' must be a string or a list of strings", key)
+		return nil
+	}
+	return getStringList(value)
+}
+
+// oneOf reads a string that must be one of the allowed values, returning def when unset
+func (r *configReader) oneOf(key string, def string, allowed ...string) string {
+	value := r.str(key)
+	if value == "" {
+		return def
+	}
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	r.errorf("'%s' must be one of %s, got %q", key, strings.Join(allowed, ", "), value)
+	return def
+}
+
+// checkURL validates an absolute URL with one of the given schemes
+func (r *configReader) checkURL(key, value string, schemes ...string) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		r.errorf("'%s' is not a valid URL: %q", key, value)
+		return
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return
+		}
+	}
+	r.errorf("'%s' must use one of %s, got %q", key, strings.Join(schemes, ", "), value)
+}
+
+// checkFile validates that a path given in the config is an existing regular file
+func (r *configReader) checkFile(key, path string) {
+	if path == "" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		r.errorf("'%s' %s: %v", key, path, err)
+		return
+	}
+	if info.IsDir() {
+		r.errorf("'%s' %s is a directory", key, path)
+	}
+}
+
+// scriptPolicy reads one entry of script_policy
+func (r *configReader) scriptPolicy(name string, value interface{}) ScriptPolicy {
+	var policy ScriptPolicy
+	options, ok := value.(map[string]interface{})
+	if !ok {
+		r.errorf("'script_policy' entry for %q must be an object", name)
+		return policy
+	}
+	for key, option := range options {
+		switch key {
+		case "args_pattern":
+			pattern, ok := option.(string)
+			if !ok {
+				r.errorf("'script_policy' args_pattern for %q must be a string", name)
+				continue
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				r.errorf("'script_policy' args_pattern for %q is not a valid regular expression: %v", name, err)
+				continue
+			}
+			// Anchored so the whole argument has to match
+			policy.ArgsPattern = regexp.MustCompile("^(?:" + pattern + ")$")
+		case "args_enum":
+			items, ok := option.([]interface{})
+			if !ok {
+				r.errorf("'script_policy' args_enum for %q must be a list of strings", name)
+				continue
+			}
+			for _, item := range items {
+				s, ok := item.(string)
+				if !ok {
+					r.errorf("'script_policy' args_enum for %q must be a list of strings", name)
+					break
+				}
+				policy.ArgsEnum = append(policy.ArgsEnum, s)
+			}
+		case "max_concurrent":
+			n, ok := option.(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				r.errorf("'script_policy' max_concurrent for %q must be a whole number", name)
+				continue
+			}
+			policy.MaxConcurrent = int(n)
+		default:
+			r.errorf("unknown key '%s' in 'script_policy' entry for %q", key, name)
+		}
+	}
+	return policy
+}
+
+// unknown reports keys in the scout section that nothing read
+func (r *configReader) unknown() {
+	var keys []string
+	for key := range r.options {
+		if !r.read[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r.errorf("unknown key '%s'", key)
+	}
+}
+
+// keyFingerprint identifies a PEM encoded key or certificate without revealing it: the SHA-256
+// of the DER bytes, or of the raw string if it isn't PEM
+func keyFingerprint(pemData string) string {
+	if pemData == "" {
+		return ""
+	}
+	data := []byte(pemData)
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// parseScoutConfig builds the config from the scout section of the osquery config. configPath is
+// the file it came from, used for the default cache_dir. Every problem found is returned
+// together as configErrors.
+func parseScoutConfig(scoutOptions map[string]interface{}, configPath string) (ScoutConfig, error) {
+	r := newConfigReader(scoutOptions)
+	config := ScoutConfig{}
+
+	// Script servers, in priority order
+	serverList, _ := r.lookup("script_server_urls")
+	if serverList != nil {
+		items, ok := serverList.([]interface{})
+		if !ok {
+			r.errorf("'script_server_urls' must be a list")
+		}
+		for _, item := range items {
+			switch v := item.(type) {
+			case string:
+			case map[string]interface{}:
+				if _, ok := v["url"].(string); !ok {
+					r.errorf("'script_server_urls' entries must have a 'url' string")
+				}
+				if priority, ok := v["priority"]; ok {
+					if _, ok := priority.(float64); !ok {
+						r.errorf("'script_server_urls' priority must be a number")
+					}
+				}
+			default:
+				r.errorf("'script_server_urls' entries must be URLs or {\"url\", \"priority\"} objects")
+			}
+		}
+		config.ServerURLs = getServerList(serverList)
+		for _, serverURL := range config.ServerURLs {
+			r.checkURL("script_server_urls", serverURL, "http", "https")
+		}
+	}
+	if serverURL := r.str("script_server_url"); serverURL != "" {
+		r.checkURL("script_server_url", serverURL, "http", "https")
+		config.ServerURLs = append([]string{serverURL}, config.ServerURLs...)
+	}
+	if len(config.ServerURLs) == 0 {
+		r.errorf("no 'script_server_url' or 'script_server_urls'")
+	} else {
+		config.ServerURL = config.ServerURLs[0]
+	}
+
+	config.PublicKey = r.str("public_key")
+	if config.PublicKey == "" {
+		r.errorf("no 'public_key'")
+	} else if block, _ := pem.Decode([]byte(config.PublicKey)); block == nil {
+		r.errorf("'public_key' is not a PEM encoded key")
+	} else if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		r.errorf("'public_key' could not be parsed: %v", err)
+	} else if _, ok := pub.(*rsa.PublicKey); !ok {
+		r.errorf("'public_key' must be an RSA key")
+	}
+
+	config.CacheWindow = r.duration("cache_window_seconds", time.Hour, time.Second)
+	config.ExecTimeout = r.duration("exec_timeout_seconds", 60*time.Second, time.Second)
+
+	// HTTP client
+	config.ConnectTimeout = r.duration("connect_timeout_seconds", 10*time.Second, time.Second)
+	config.ReadTimeout = r.duration("read_timeout_seconds", 30*time.Second, time.Second)
+	config.MaxDownloadBytes = r.integer("max_download_bytes", 10*1024*1024)
+	config.MaxRetries = int(r.integer("max_retries", 3))
+	config.RetryBackoff = r.duration("retry_backoff_ms", 500*time.Millisecond, time.Millisecond)
+	config.RetryMaxBackoff = r.duration("retry_max_backoff_seconds", 30*time.Second, time.Second)
+
+	// TLS
+	config.CABundle = r.str("ca_bundle")
+	r.checkFile("ca_bundle", config.CABundle)
+	config.ClientCert = r.str("client_cert")
+	r.checkFile("client_cert", config.ClientCert)
+	config.ClientKey = r.str("client_key")
+	r.checkFile("client_key", config.ClientKey)
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		r.errorf("'client_cert' and 'client_key' must be set together")
+	}
+	config.ServerName = r.str("server_name")
+	config.MinTLSVersion = r.oneOf("min_tls_version", "", "1.0", "1.1", "1.2", "1.3")
+	config.SPKIPins = r.stringList("spki_pins")
+
+	// Proxy and authentication
+	config.ProxyURL = r.str("proxy_url")
+	if config.ProxyURL != "" {
+		r.checkURL("proxy_url", config.ProxyURL, "http", "https", "socks5")
+	}
+	config.NoProxy = r.stringList("no_proxy")
+	config.BearerToken = r.str("bearer_token")
+	config.BearerTokenFile = r.str("bearer_token_file")
+	r.checkFile("bearer_token_file", config.BearerTokenFile)
+	config.UseEnrollSecret = r.boolean("use_enroll_secret")
+	config.APIKey = r.str("api_key")
+	config.APIKeyHeader = r.str("api_key_header")
+
+	// Failover and offline behaviour
+	config.BreakerThreshold = int(r.integer("breaker_threshold", 3))
+	config.BreakerCooldown = r.duration("breaker_cooldown_seconds", 60*time.Second, time.Second)
+	config.OfflinePolicy = r.oneOf("offline_policy", offlineStrict, offlineStrict, offlineAllowStale, offlineAlwaysAllowVerified)
+	config.OfflineMaxStale = r.duration("offline_max_stale_hours", 24*time.Hour, time.Hour)
+
+	// Cache
+	config.CacheDir = filepath.Join(filepath.Dir(configPath), cacheDirName)
+	if dir := r.str("cache_dir"); dir != "" {
+		config.CacheDir = dir
+	}
+	if info, err := os.Stat(config.CacheDir); err == nil && !info.IsDir() {
+		r.errorf("'cache_dir' %s is not a directory", config.CacheDir)
+	}
+	config.MaxCacheBytes = r.integer("max_cache_bytes", 0)
+	config.MaxCacheEntries = int(r.integer("max_cache_entries", 0))
+	config.CacheJanitorInterval = r.duration("cache_janitor_interval_seconds", 5*time.Minute, time.Second)
+	config.Prefetch = r.stringList("prefetch")
+	config.PrefetchInterval = r.duration("prefetch_interval_seconds", time.Hour, time.Second)
+	config.IntegrityScanInterval = r.duration("integrity_scan_interval_seconds", 0, time.Second)
+	config.AllowCacheOps = r.boolean("allow_cache_ops")
+	config.ConfigReloadInterval = r.duration("config_reload_interval_seconds", 30*time.Second, time.Second)
+	config.OsqueryConfigRefresh = r.duration("osquery_config_refresh_seconds", time.Hour, time.Second)
+
+	config.ResultCacheTTLs = make(map[string]time.Duration)
+	if value, ok := r.lookup("result_cache"); ok {
+		ttls, ok := value.(map[string]interface{})
+		if !ok {
+			r.errorf("'result_cache' must be an object of script names to seconds")
+		}
+		for name, ttl := range ttls {
+			seconds, ok := ttl.(float64)
+			if !ok || seconds < 0 {
+				r.errorf("'result_cache' ttl for %q must be a number of seconds", name)
+				continue
+			}
+			config.ResultCacheTTLs[name] = time.Duration(seconds * float64(time.Second))
+		}
+	}
+
+	config.EncryptCache = r.boolean("encrypt_cache")
+	config.CacheKeySource = r.oneOf("cache_key_source", cacheKeySourceFile, cacheKeySourceFile, cacheKeySourceOsquery)
+	// Next to cache_dir rather than in it, so a copy of the cache directory alone can't be decrypted
+	config.CacheKeyFile = filepath.Join(filepath.Dir(filepath.Clean(config.CacheDir)), cacheKeyFileName)
+	if keyFile := r.str("cache_key_file"); keyFile != "" {
+		config.CacheKeyFile = keyFile
+	}
+
+	config.FanOutWorkers = int(r.integer("fan_out_workers", 4))
+	if config.FanOutWorkers == 0 {
+		r.errorf("'fan_out_workers' must be at least 1")
+	}
+
+	// Concurrency limits
+	config.MaxConcurrentExecutions = int(r.integer("max_concurrent_executions", 8))
+	config.MaxConcurrentPerScript = int(r.integer("max_concurrent_per_script", 0))
+	config.ExecutionQueueSize = int(r.integer("execution_queue_size", 32))
+	config.ExecutionQueueTimeout = r.duration("execution_queue_timeout_seconds", 30*time.Second, time.Second)
+	if config.ExecutionQueueTimeout == 0 {
+		// A run that has to queue would time out straight away
+		r.errorf("'execution_queue_timeout_seconds' must be more than 0, use 'execution_queue_size' 0 to disable the queue")
+	}
+
+	// Load-aware throttling
+	config.ThrottleMaxLoad = r.number("throttle_max_load", 0)
+	config.ThrottleMinFreeMemoryMB = r.number("throttle_min_free_memory_mb", 0)
+	config.ThrottleMaxCPUPercent = r.number("throttle_max_cpu_percent", 0)
+	if config.ThrottleMaxCPUPercent > 100 {
+		r.errorf("'throttle_max_cpu_percent' must be at most 100")
+	}
+	config.ThrottleAction = r.oneOf("throttle_action", throttleActionDefer, throttleActionDefer, throttleActionReject, throttleActionNice)
+	config.ThrottleDeferMax = r.duration("throttle_defer_max_seconds", 60*time.Second, time.Second)
+	config.ThrottleNice = int(r.integer("throttle_nice", 10))
+	if config.ThrottleNice < 1 || config.ThrottleNice > 19 {
+		r.errorf("'throttle_nice' must be between 1 and 19")
+	}
+
+	// Endpoint policy
+	config.AllowScripts = r.stringList("allow_scripts")
+	config.DenyScripts = r.stringList("deny_scripts")
+	for key, patterns := range map[string][]string{"allow_scripts": config.AllowScripts, "deny_scripts": config.DenyScripts} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				r.errorf("'%s' has an invalid glob %q", key, pattern)
+			}
+		}
+	}
+	config.ScriptPolicies = make(map[string]ScriptPolicy)
+	if value, ok := r.lookup("script_policy"); ok {
+		policies, ok := value.(map[string]interface{})
+		if !ok {
+			r.errorf("'script_policy' must be an object of script names to policies")
+		}
+		for name, value := range policies {
+			config.ScriptPolicies[name] = r.scriptPolicy(name, value)
+		}
+	}
+
+	r.unknown()
+	if len(r.errs) > 0 {
+		return config, r.errs
+	}
+	return config, nil
+}
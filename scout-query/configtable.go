@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// ScoutConfigGenerate reports the settings the extension is running with, one row per key,
+// so fleets can be audited for drift. Keys and tokens are shown as fingerprints or redacted.
+func ScoutConfigGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	config := currentConfig()
+
+	seconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+	redacted := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return "<redacted>"
+	}
+
+	settings := [][2]string{
+		{"version", Version},
+		{"config_source", config.SourcePath},
+		{"config_loaded", configLoadedAt().Format(time.RFC3339)},
+
+		{"script_server_urls", strings.Join(config.ServerURLs, ",")},
+		{"public_key", keyFingerprint(config.PublicKey)},
+		{"cache_window_seconds", seconds(config.CacheWindow)},
+		{"exec_timeout_seconds", seconds(config.ExecTimeout)},
+
+		{"connect_timeout_seconds", seconds(config.ConnectTimeout)},
+		{"read_timeout_seconds", seconds(config.ReadTimeout)},
+		{"max_download_bytes", strconv.FormatInt(config.MaxDownloadBytes, 10)},
+		{"max_retries", strconv.Itoa(config.MaxRetries)},
+		{"retry_backoff_ms", strconv.FormatInt(config.RetryBackoff.Milliseconds(), 10)},
+		{"retry_max_backoff_seconds", seconds(config.RetryMaxBackoff)},
+
+		{"ca_bundle", config.CABundle},
+		{"ca_bundle_fingerprint", fileFingerprint(config.CABundle)},
+		{"client_cert", config.ClientCert},
+		{"client_cert_fingerprint", fileFingerprint(config.ClientCert)},
+		{"client_key", config.ClientKey},
+		{"server_name", config.ServerName},
+		{"min_tls_version", config.MinTLSVersion},
+		{"spki_pins", strings.Join(config.SPKIPins, ",")},
+
+		{"proxy_url", redactURL(config.ProxyURL)},
+		{"no_proxy", strings.Join(config.NoProxy, ",")},
+		{"bearer_token", redacted(config.BearerToken)},
+		{"bearer_token_file", config.BearerTokenFile},
+		{"use_enroll_secret", strconv.FormatBool(config.UseEnrollSecret)},
+		{"api_key", redacted(config.APIKey)},
+		{"api_key_header", config.APIKeyHeader},
+
+		{"breaker_threshold", strconv.Itoa(config.BreakerThreshold)},
+		{"breaker_cooldown_seconds", seconds(config.BreakerCooldown)},
+		{"offline_policy", config.OfflinePolicy},
+		{"offline_max_stale_hours", strconv.FormatFloat(config.OfflineMaxStale.Hours(), 'f', -1, 64)},
+
+		{"cache_dir", config.CacheDir},
+		{"cache_db", cacheDBPath},
+		{"max_cache_bytes", strconv.FormatInt(config.MaxCacheBytes, 10)},
+		{"max_cache_entries", strconv.Itoa(config.MaxCacheEntries)},
+		{"cache_janitor_interval_seconds", seconds(config.CacheJanitorInterval)},
+		{"prefetch", strings.Join(config.Prefetch, ",")},
+		{"prefetch_interval_seconds", seconds(config.PrefetchInterval)},
+		{"integrity_scan_interval_seconds", seconds(config.IntegrityScanInterval)},
+		{"allow_cache_ops", strconv.FormatBool(config.AllowCacheOps)},
+		{"config_reload_interval_seconds", seconds(config.ConfigReloadInterval)},
+		{"osquery_config_refresh_seconds", seconds(config.OsqueryConfigRefresh)},
+		{"fan_out_workers", strconv.Itoa(config.FanOutWorkers)},
+		{"max_concurrent_executions", strconv.Itoa(config.MaxConcurrentExecutions)},
+		{"max_concurrent_per_script", strconv.Itoa(config.MaxConcurrentPerScript)},
+		{"execution_queue_size", strconv.Itoa(config.ExecutionQueueSize)},
+		{"execution_queue_timeout_seconds", seconds(config.ExecutionQueueTimeout)},
+		{"throttle_max_load", strconv.FormatFloat(config.ThrottleMaxLoad, 'f', -1, 64)},
+		{"throttle_min_free_memory_mb", strconv.FormatFloat(config.ThrottleMinFreeMemoryMB, 'f', -1, 64)},
+		{"throttle_max_cpu_percent", strconv.FormatFloat(config.ThrottleMaxCPUPercent, 'f', -1, 64)},
+		{"throttle_action", config.ThrottleAction},
+		{"throttle_defer_max_seconds", seconds(config.ThrottleDeferMax)},
+		{"throttle_nice", strconv.Itoa(config.ThrottleNice)},
+		{"encrypt_cache", strconv.FormatBool(config.EncryptCache)},
+		{"cache_key_source", config.CacheKeySource},
+		{"cache_key_file", config.CacheKeyFile},
+		{"cache_key_id", cacheKeyID},
+	}
+
+	for _, name := range sortedKeys(config.ResultCacheTTLs) {
+		settings = append(settings, [2]string{"result_cache." + name, seconds(config.ResultCacheTTLs[name])})
+	}
+
+	settings = append(settings,
+		[2]string{"allow_scripts", strings.Join(config.AllowScripts, ",")},
+		[2]string{"deny_scripts", strings.Join(config.DenyScripts, ",")},
+	)
+	for _, name := range sortedKeys(config.ScriptPolicies) {
+		policy := config.ScriptPolicies[name]
+		if policy.ArgsPattern != nil {
+			settings = append(settings, [2]string{"script_policy." + name + ".args_pattern", policy.ArgsPattern.String()})
+		}
+		if len(policy.ArgsEnum) > 0 {
+			settings = append(settings, [2]string{"script_policy." + name + ".args_enum", strings.Join(policy.ArgsEnum, ",")})
+		}
+		settings = append(settings, [2]string{"script_policy." + name + ".max_concurrent", strconv.Itoa(policy.MaxConcurrent)})
+	}
+
+	var results []map[string]string
+	for _, setting := range settings {
+		results = append(results, map[string]string{
+			"key":   setting[0],
+			"value": setting[1],
+		})
+	}
+	return results, nil
+}
+
+// Helper function to hide the password in a proxy URL
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// Helper function to list the keys of a map in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Helper function to fingerprint a PEM file named in the config, empty if unset or unreadable
+func fileFingerprint(path string) string {
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return keyFingerprint(string(data))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Statuses of scout_exec runs that never got to execute
+const (
+	statusQueueFull    = "queue_full"    // Every slot was busy and the wait queue was full
+	statusQueueTimeout = "queue_timeout" // Waited for a slot longer than execution_queue_timeout_seconds
+)
+
+// queueError is a run refused by the execution limits, reported with its status
+type queueError struct {
+	status string
+	reason string
+}
+
+func (e *queueError) Error() string {
+	return e.reason
+}
+
+// execWaiter is a run waiting in the queue for a slot
+type execWaiter struct {
+	scriptName  string
+	scriptLimit int
+	ready       chan struct{} // Closed once the run has been given a slot
+}
+
+// execLimiter caps how many scripts run at once, overall and per script. Runs over a limit
+// wait their turn in a bounded queue.
+type execLimiter struct {
+	mutex     sync.Mutex
+	running   int
+	perScript map[string]int
+	waiting   []*execWaiter
+}
+
+// Limits every script execution, see acquire
+var executions = &execLimiter{perScript: make(map[string]int)}
+
+// scriptConcurrencyLimit is the per-script limit: max_concurrent in the script's policy, else
+// max_concurrent_per_script. 0 is unlimited.
+func scriptConcurrencyLimit(config *ScoutConfig, scriptName string) int {
+	if policy, ok := lookupByScriptName(config.ScriptPolicies, scriptName); ok && policy.MaxConcurrent > 0 {
+		return policy.MaxConcurrent
+	}
+	return config.MaxConcurrentPerScript
+}
+
+// Helper function to check whether a run fits the limits, must be called with the mutex held
+func (l *execLimiter) fits(globalLimit int, scriptName string, scriptLimit int) bool {
+	if globalLimit > 0 && l.running >= globalLimit {
+		return false
+	}
+	return scriptLimit <= 0 || l.perScript[scriptName] < scriptLimit
+}
+
+// Helper function to take a slot, must be called with the mutex held
+func (l *execLimiter) take(scriptName string) {
+	l.running++
+	l.perScript[scriptName]++
+}
+
+// acquire waits for a slot to run the script in, for at most execution_queue_timeout_seconds.
+// It returns how long the run was queued and a function that must be called once the run
+// ends. A run is refused straight away when the queue is already full.
+func (l *execLimiter) acquire(config *ScoutConfig, scriptName string) (func(), time.Duration, error) {
+	scriptLimit := scriptConcurrencyLimit(config, scriptName)
+	release := func() { l.release(scriptName) }
+
+	l.mutex.Lock()
+	// Anyone still waiting is blocked on a limit, so this run only gets ahead of them if it
+	// fits under limits they can't
+	if l.fits(config.MaxConcurrentExecutions, scriptName, scriptLimit) {
+		l.take(scriptName)
+		l.mutex.Unlock()
+		return release, 0, nil
+	}
+	if len(l.waiting) >= config.ExecutionQueueSize {
+		l.mutex.Unlock()
+		metricQueueRejections.inc()
+		return nil, 0, &queueError{statusQueueFull, fmt.Sprintf("too many scripts running, %d already waiting", config.ExecutionQueueSize)}
+	}
+	waiter := &execWaiter{scriptName: scriptName, scriptLimit: scriptLimit, ready: make(chan struct{})}
+	l.waiting = append(l.waiting, waiter)
+	l.mutex.Unlock()
+	metricExecutionsQueued.inc()
+
+	start := time.Now()
+	timer := time.NewTimer(config.ExecutionQueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return release, time.Since(start), nil
+	case <-timer.C:
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	select {
+	case <-waiter.ready:
+		// Given a slot just as the timeout fired
+		return release, time.Since(start), nil
+	default:
+	}
+	for i, w := range l.waiting {
+		if w == waiter {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			break
+		}
+	}
+	metricQueueRejections.inc()
+	return nil, time.Since(start), &queueError{statusQueueTimeout, fmt.Sprintf("no slot to run %s after waiting %s", scriptName, config.ExecutionQueueTimeout)}
+}
+
+// release frees the slot of a finished run and hands slots to waiting runs in queue order
+func (l *execLimiter) release(scriptName string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.running--
+	l.perScript[scriptName]--
+	if l.perScript[scriptName] <= 0 {
+		delete(l.perScript, scriptName)
+	}
+
+	globalLimit := currentConfig().MaxConcurrentExecutions
+	remaining := l.waiting[:0]
+	for _, waiter := range l.waiting {
+		if l.fits(globalLimit, waiter.scriptName, waiter.scriptLimit) {
+			l.take(waiter.scriptName)
+			close(waiter.ready)
+			continue
+		}
+		remaining = append(remaining, waiter)
+	}
+	l.waiting = remaining
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// scriptRun is one script and args combination of a scout_exec query
+type scriptRun struct {
+	scriptName string
+	argsList   []string // A single args value, or none
+}
+
+// fanOutRuns pairs every script with every args value, so
+// script_name IN ('a.sh', 'b.sh') AND args IN ('x', 'y') runs four times
+func fanOutRuns(scriptNames []string, argsValues []string) []scriptRun {
+	var runs []scriptRun
+	for _, scriptName := range scriptNames {
+		if len(argsValues) == 0 {
+			runs = append(runs, scriptRun{scriptName: scriptName})
+			continue
+		}
+		for _, args := range argsValues {
+			runs = append(runs, scriptRun{scriptName: scriptName, argsList: []string{args}})
+		}
+	}
+	return runs
+}
+
+// runFanOut runs each of the runs on at most workers goroutines and combines their rows in the
+// order of runs. A run that fails gets a "failed" row with the error instead of failing the
+// whole query.
+func runFanOut(runs []scriptRun, workers int, fn func(run scriptRun) ([]map[string]string, error)) []map[string]string {
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(runs) {
+		workers = len(runs)
+	}
+
+	results := make([][]map[string]string, len(runs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				run := runs[i]
+				rows, err := fn(run)
+				if err != nil {
+					log.Printf("Script run failed: %s with args: %v: %v\n", run.scriptName, run.argsList, err)
+					rows = []map[string]string{errorRow(run.scriptName, strings.Join(run.argsList, " "), "failed", err)}
+				}
+				results[i] = rows
+			}
+		}()
+	}
+	for i := range runs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var rows []map[string]string
+	for _, runRows := range results {
+		rows = append(rows, runRows...)
+	}
+	return rows
+}
+
+// Helper function to drop repeated values, keeping the first of each
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
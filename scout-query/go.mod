@@ -7,6 +7,8 @@ toolchain go1.23.2
 require (
 	github.com/kluctl/go-embed-python v0.0.0-3.12.3-20240415-2
 	github.com/osquery/osquery-go v0.0.0-20240910233439-561a72587be6
+	golang.org/x/sync v0.10.0
+	golang.org/x/sys v0.28.0
 	modernc.org/sqlite v1.34.3
 )
 
@@ -29,8 +31,6 @@ require (
 	go.opentelemetry.io/otel/metric v1.33.0 // indirect
 	go.opentelemetry.io/otel/trace v1.33.0 // indirect
 	golang.org/x/exp v0.0.0-20241217172543-b2144cdd0a67 // indirect
-	golang.org/x/sync v0.10.0 // indirect
-	golang.org/x/sys v0.28.0 // indirect
 	modernc.org/gc/v3 v3.0.0-20241213165251-3bc300f6d0c9 // indirect
 	modernc.org/libc v1.61.4 // indirect
 	modernc.org/mathutil v1.6.0 // indirect
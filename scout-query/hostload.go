@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What to do with a script when the host is over a throttle threshold
+const (
+	throttleActionDefer  = "defer"  // Wait for the load to drop, up to throttle_defer_max_seconds
+	throttleActionReject = "reject" // Refuse to run the script
+	throttleActionNice   = "nice"   // Run the script at a lower CPU and IO priority
+)
+
+// Throttle decisions reported in the throttle column of scout_exec
+const (
+	throttleNone        = "none"        // The host was within every threshold
+	throttleDeferred    = "deferred"    // The run waited for the load to drop
+	throttleNiced       = "niced"       // The run was given a lower priority
+	throttleNiceFailed  = "nice_failed" // The run should have been niced but ran at normal priority
+	throttleRejected    = "rejected"    // The run was refused
+	throttleUnavailable = "unavailable" // The load couldn't be read on this host, the run went ahead
+)
+
+// How often a deferred run checks the load again
+const throttleDeferPoll = 2 * time.Second
+
+var errHostLoadUnsupported = errors.New("reading the host load is not supported on this platform")
+
+// hostLoad is a snapshot of how busy the host is
+type hostLoad struct {
+	LoadPerCPU     float64 // 1 minute load average divided by the number of CPUs
+	MemAvailableMB float64 // Memory available to new processes without swapping
+	CPUBusyPercent float64 // Share of CPU time not spent idle over the last few seconds
+}
+
+// throttleDecision is what decideThrottle made of the host load for one run
+type throttleDecision struct {
+	Action   string // One of the throttle decisions, empty when no thresholds are configured
+	Reason   string // The threshold that was exceeded
+	Niceness int    // Niceness to run the script with, 0 for normal priority
+}
+
+// Logged once, the error repeats on every run
+var hostLoadErrorOnce sync.Once
+
+// Helper function to check whether any throttle threshold is configured
+func throttleEnabled(config *ScoutConfig) bool {
+	return config.ThrottleMaxLoad > 0 || config.ThrottleMinFreeMemoryMB > 0 || config.ThrottleMaxCPUPercent > 0
+}
+
+// hostOverloaded describes each threshold the host is over, or returns "" if none
+func hostOverloaded(config *ScoutConfig) (string, error) {
+	load, err := readHostLoad(config.ThrottleMaxCPUPercent > 0)
+	if err != nil {
+		return "", err
+	}
+
+	var reasons []string
+	if config.ThrottleMaxLoad > 0 && load.LoadPerCPU > config.ThrottleMaxLoad {
+		reasons = append(reasons, fmt.Sprintf("load per cpu %.2f above %.2f", load.LoadPerCPU, config.ThrottleMaxLoad))
+	}
+	if config.ThrottleMinFreeMemoryMB > 0 && load.MemAvailableMB < config.ThrottleMinFreeMemoryMB {
+		reasons = append(reasons, fmt.Sprintf("available memory %.0f MB below %.0f MB", load.MemAvailableMB, config.ThrottleMinFreeMemoryMB))
+	}
+	if config.ThrottleMaxCPUPercent > 0 && load.CPUBusyPercent > config.ThrottleMaxCPUPercent {
+		reasons = append(reasons, fmt.Sprintf("cpu %.0f%% busy above %.0f%%", load.CPUBusyPercent, config.ThrottleMaxCPUPercent))
+	}
+	return strings.Join(reasons, ", "), nil
+}
+
+// decideThrottle checks the host load against the throttle thresholds before a script runs
+// and applies throttle_action when it is over one. A deferred run that is still over a
+// threshold after throttle_defer_max_seconds is rejected.
+func decideThrottle(config *ScoutConfig, scriptName string) throttleDecision {
+	if !throttleEnabled(config) {
+		return throttleDecision{}
+	}
+
+	reason, err := hostOverloaded(config)
+	if err != nil {
+		hostLoadErrorOnce.Do(func() {
+			log.Printf("Failed to read host load, scripts will run without throttling: %v\n", err)
+		})
+		return throttleDecision{Action: throttleUnavailable}
+	}
+	if reason == "" {
+		return throttleDecision{Action: throttleNone}
+	}
+
+	switch config.ThrottleAction {
+	case throttleActionReject:
+		return throttleDecision{Action: throttleRejected, Reason: reason}
+	case throttleActionNice:
+		return throttleDecision{Action: throttleNiced, Reason: reason, Niceness: config.ThrottleNice}
+	}
+
+	log.Printf("Deferring script: %s until the host load drops: %s\n", scriptName, reason)
+	metricThrottleDeferrals.inc()
+	deadline := time.Now().Add(config.ThrottleDeferMax)
+	for time.Now().Before(deadline) {
+		time.Sleep(throttleDeferPoll)
+		current, err := hostOverloaded(config)
+		if err != nil {
+			return throttleDecision{Action: throttleUnavailable}
+		}
+		if current == "" {
+			return throttleDecision{Action: throttleDeferred, Reason: reason}
+		}
+		reason = current
+	}
+	return throttleDecision{Action: throttleRejected, Reason: reason}
+}
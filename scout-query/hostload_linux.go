@@ -0,0 +1,187 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CPU usage is measured between two readings of /proc/stat. A reading this recent is reused,
+// one older than cpuSampleMaxAge is too stale to compare with and a fresh pair is taken.
+const (
+	cpuSampleMinAge = time.Second
+	cpuSampleMaxAge = 30 * time.Second
+	cpuSampleWindow = 250 * time.Millisecond
+)
+
+var (
+	cpuSampleMutex sync.Mutex
+	lastCPUBusy    uint64
+	lastCPUTotal   uint64
+	lastCPUSample  time.Time
+	lastCPUPercent float64
+)
+
+// readHostLoad reads the load average, available memory and, if wanted, CPU usage from /proc
+func readHostLoad(withCPU bool) (hostLoad, error) {
+	var load hostLoad
+
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return load, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return load, fmt.Errorf("unexpected /proc/loadavg format")
+	}
+	load1, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return load, fmt.Errorf("unexpected /proc/loadavg format: %v", err)
+	}
+	load.LoadPerCPU = load1 / float64(runtime.NumCPU())
+
+	load.MemAvailableMB, err = readMemAvailableMB()
+	if err != nil {
+		return load, err
+	}
+
+	if withCPU {
+		load.CPUBusyPercent, err = cpuBusyPercent()
+		if err != nil {
+			return load, err
+		}
+	}
+	return load, nil
+}
+
+// Helper function to read MemAvailable from /proc/meminfo, estimating it on kernels older than 3.14
+func readMemAvailableMB() (float64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// e.g. "MemAvailable:   12345678 kB"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if kb, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			values[strings.TrimSuffix(fields[0], ":")] = kb
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if available, ok := values["MemAvailable"]; ok {
+		return available / 1024, nil
+	}
+	if free, ok := values["MemFree"]; ok {
+		return (free + values["Buffers"] + values["Cached"]) / 1024, nil
+	}
+	return 0, fmt.Errorf("no MemAvailable or MemFree in /proc/meminfo")
+}
+
+// Helper function to read the busy and total CPU time from the first line of /proc/stat
+func readCPUTimes() (busy uint64, total uint64, err error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return 0, 0, fmt.Errorf("empty /proc/stat")
+	}
+	// cpu user nice system idle iowait irq softirq steal guest guest_nice; guest time is
+	// already counted in user and nice
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected /proc/stat format")
+	}
+	var idle uint64
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		n, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unexpected /proc/stat format: %v", err)
+		}
+		total += n
+		if i == 3 || i == 4 {
+			idle += n
+		}
+	}
+	return total - idle, total, nil
+}
+
+// Helper function to work out CPU usage since the last reading of /proc/stat
+func cpuBusyPercent() (float64, error) {
+	cpuSampleMutex.Lock()
+	defer cpuSampleMutex.Unlock()
+
+	age := time.Since(lastCPUSample)
+	if !lastCPUSample.IsZero() && age < cpuSampleMinAge {
+		return lastCPUPercent, nil
+	}
+	if lastCPUSample.IsZero() || age > cpuSampleMaxAge {
+		// Nothing recent to compare with, take a short sample
+		busy, total, err := readCPUTimes()
+		if err != nil {
+			return 0, err
+		}
+		lastCPUBusy, lastCPUTotal = busy, total
+		time.Sleep(cpuSampleWindow)
+	}
+
+	busy, total, err := readCPUTimes()
+	if err != nil {
+		return 0, err
+	}
+	if total > lastCPUTotal && busy >= lastCPUBusy {
+		lastCPUPercent = float64(busy-lastCPUBusy) / float64(total-lastCPUTotal) * 100
+	}
+	lastCPUBusy, lastCPUTotal = busy, total
+	lastCPUSample = time.Now()
+	return lastCPUPercent, nil
+}
+
+// lowPriorityCommand wraps a script command in nice and ionice, so the script starts with the
+// given niceness in the idle IO class instead of being reniced once it is already running.
+// Both exec the script in place, so killing the command on timeout still kills the script.
+func lowPriorityCommand(ctx context.Context, cmd *exec.Cmd, niceness int) (*exec.Cmd, error) {
+	nicePath, err := exec.LookPath("nice")
+	if err != nil {
+		return nil, fmt.Errorf("nice not found: %v", err)
+	}
+	args := []string{"-n", strconv.Itoa(niceness)}
+	if ionicePath, err := exec.LookPath("ionice"); err == nil {
+		args = append(args, ionicePath, "-c3")
+	} else {
+		log.Printf("ionice not found, script IO priority is left unchanged\n")
+	}
+	args = append(args, cmd.Path)
+	args = append(args, cmd.Args[1:]...)
+
+	wrapped := exec.CommandContext(ctx, nicePath, args...)
+	wrapped.Env = cmd.Env
+	wrapped.Dir = cmd.Dir
+	return wrapped, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// The host load is read from /proc, which only Linux has
+func readHostLoad(withCPU bool) (hostLoad, error) {
+	return hostLoad{}, errHostLoadUnsupported
+}
+
+func lowPriorityCommand(ctx context.Context, cmd *exec.Cmd, niceness int) (*exec.Cmd, error) {
+	return nil, errHostLoadUnsupported
+}
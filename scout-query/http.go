@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Shared client for all requests to the script server, see newHTTPClient. Replaced when the
+// config is reloaded; requests in flight keep the client they started with.
+var httpClient atomic.Pointer[http.Client]
+
+// newHTTPClient builds the HTTP client used for script downloads from the scout config
+func newHTTPClient(config ScoutConfig) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := newProxyFunc(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   config.ConnectTimeout,
+		ResponseHeaderTimeout: config.ReadTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	authenticated, err := newAuthTransport(transport, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: authenticated,
+		// Hard cap on a single attempt, including reading the body
+		Timeout: config.ConnectTimeout + config.ReadTimeout,
+	}, nil
+}
+
+// doRequest sends a request to the script server, retrying network errors, 5xx and 429
+// responses with exponential backoff and jitter. The caller must close the response body.
+func doRequest(req *http.Request) (*http.Response, error) {
+	client := httpClient.Load()
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	config := currentConfig()
+	maxRetries := config.MaxRetries
+	var resp *http.Response
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		resp, err = client.Do(req.Clone(req.Context()))
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if attempt >= maxRetries {
+			break
+		}
+
+		wait := retryBackoff(config, attempt)
+		if err != nil {
+			log.Printf("Request to %s failed (attempt %d/%d): %v, retrying in %s\n", req.URL, attempt+1, maxRetries+1, err, wait)
+		} else {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = retryAfter
+				if config.RetryMaxBackoff > 0 && wait > config.RetryMaxBackoff {
+					wait = config.RetryMaxBackoff
+				}
+			}
+			log.Printf("Request to %s returned status %d (attempt %d/%d), retrying in %s\n", req.URL, resp.StatusCode, attempt+1, maxRetries+1, wait)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	// Out of retries, hand the last response back so the caller can report the status
+	return resp, nil
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
+
+// Exponential backoff with full jitter, capped at RetryMaxBackoff
+func retryBackoff(config *ScoutConfig, attempt int) time.Duration {
+	base := config.RetryBackoff
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+	backoff := base << uint(attempt)
+	if backoff <= 0 || (config.RetryMaxBackoff > 0 && backoff > config.RetryMaxBackoff) {
+		backoff = config.RetryMaxBackoff
+	}
+	if backoff <= 0 {
+		return base
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
+
+// Parse a Retry-After header given either as delay seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// readResponseBody reads the response body, refusing anything larger than MaxDownloadBytes
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	maxBytes := currentConfig().MaxDownloadBytes
+	if maxBytes <= 0 {
+		return io.ReadAll(resp.Body)
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("response of %d bytes exceeds max download size of %d bytes", resp.ContentLength, maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("response exceeds max download size of %d bytes", maxBytes)
+	}
+	return data, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// integrityResult is the outcome of checking a cache entry against its stored hash and signature
+type integrityResult struct {
+	ComputedHash   string
+	HashMatch      bool
+	SignatureValid bool
+	DecryptFailed  bool // Encrypted contents failed authentication, so they were modified
+	KeyRetired     bool // Signed or encrypted with a key that is no longer configured, so not checked
+}
+
+func (r integrityResult) ok() bool {
+	return r.HashMatch && r.SignatureValid
+}
+
+// Helper function to describe why an entry failed its check
+func (r integrityResult) reason() string {
+	switch {
+	case r.KeyRetired:
+		return "key no longer configured"
+	case r.DecryptFailed:
+		return "decryption failed"
+	case !r.HashMatch:
+		return "hash mismatch"
+	}
+	return "signature invalid"
+}
+
+// cacheIntegrityError is a cached script that failed checkCacheEntryIntegrity when loaded
+type cacheIntegrityError struct {
+	entry  cacheEntry
+	result integrityResult
+}
+
+func (e *cacheIntegrityError) Error() string {
+	return fmt.Sprintf("cached script %s failed its integrity check: %s", e.entry.Meta.ScriptName, e.result.reason())
+}
+
+// checkCacheEntryIntegrity recomputes the SHA-256 of a cached script, compares it to the hash
+// recorded when it was cached, and verifies the cached signature against publicKey.
+// Entries recorded under a different public key or encryption key can't be judged by the
+// current ones.
+func checkCacheEntryIntegrity(entry cacheEntry, publicKey string) integrityResult {
+	if errors.Is(entry.DecryptErr, errCacheKeyUnavailable) {
+		// Encrypted by an extension with other encryption settings, e.g. one sharing cache_dir
+		return integrityResult{KeyRetired: true}
+	}
+	if entry.DecryptErr != nil {
+		return integrityResult{DecryptFailed: true}
+	}
+
+	sum := sha256.Sum256(entry.Contents)
+	result := integrityResult{ComputedHash: hex.EncodeToString(sum[:])}
+	result.HashMatch = result.ComputedHash == entry.Meta.ScriptHash
+
+	if entry.SigningKeyID != keyFingerprint(publicKey) {
+		result.KeyRetired = true
+		return result
+	}
+
+	_, err := verifyScriptSignature(entry.Contents, entry.Signature, publicKey)
+	result.SignatureValid = err == nil
+	return result
+}
+
+// startIntegrityScanner periodically checks every cache entry and quarantines the ones
+// that no longer match their hash or signature
+func startIntegrityScanner() {
+	go runPeriodically(func() time.Duration { return currentConfig().IntegrityScanInterval }, func() {
+		runIntegrityScan()
+	})
+}
+
+func runIntegrityScan() (scanned int, quarantined int) {
+	entries, err := listCachedScripts()
+	if err != nil {
+		log.Printf("Cache integrity scan failed: %v\n", err)
+		return 0, 0
+	}
+
+	publicKey := currentConfig().PublicKey
+	for _, entry := range entries {
+		scanned++
+		result := checkCacheEntryIntegrity(entry, publicKey)
+		if result.ok() {
+			continue
+		}
+		if handleFailedCacheEntry(entry, result) {
+			quarantined++
+		}
+	}
+
+	if quarantined > 0 {
+		log.Printf("Cache integrity scan checked %d entries, quarantined %d\n", scanned, quarantined)
+	}
+	return scanned, quarantined
+}
+
+// handleFailedCacheEntry removes an entry that failed checkCacheEntryIntegrity from the cache.
+// Entries under a key that is no longer configured are left over from a key rotation or other
+// encryption settings and are dropped quietly; anything else is quarantined and logged as a
+// security event. Returns whether the entry was quarantined.
+func handleFailedCacheEntry(entry cacheEntry, result integrityResult) bool {
+	if result.KeyRetired {
+		removeScriptFromCache(entry.CacheKey)
+		return false
+	}
+
+	reason := result.reason()
+	if err := quarantineCacheEntry(entry, reason); err != nil {
+		log.Printf("Failed to quarantine cached script %s: %v\n", entry.Meta.ScriptName, err)
+		return false
+	}
+	logSecurityEvent("cache_tampering", map[string]string{
+		"script_name":     entry.Meta.ScriptName,
+		"cache_key":       entry.CacheKey,
+		"reason":          reason,
+		"stored_hash":     entry.Meta.ScriptHash,
+		"computed_hash":   result.ComputedHash,
+		"signature_valid": strconv.FormatBool(result.SignatureValid),
+		"action":          "quarantined",
+	})
+	return true
+}
+
+// reconcileSigningKey brings existing entries in line with the configured public key: entries
+// signed with another key are dropped, and entries cached before the signing key was recorded
+// are stamped with it if their signature verifies, or dropped so they are downloaded again.
+func reconcileSigningKey(publicKey string) error {
+	keyID := keyFingerprint(publicKey)
+	result, err := cacheDB.Exec(`DELETE FROM scripts WHERE signing_key_id != '' AND signing_key_id != ?`, keyID)
+	if err != nil {
+		return err
+	}
+	if dropped, _ := result.RowsAffected(); dropped > 0 {
+		log.Printf("Dropped %d cached scripts signed with a key that is no longer configured\n", dropped)
+	}
+
+	entries, err := listCachedScripts()
+	if err != nil {
+		return err
+	}
+	stamped, dropped := 0, 0
+	for _, entry := range entries {
+		if entry.SigningKeyID != "" {
+			continue
+		}
+		if entry.DecryptErr == nil {
+			if _, err := verifyScriptSignature(entry.Contents, entry.Signature, publicKey); err == nil {
+				_, err = cacheDB.Exec(`UPDATE scripts SET signing_key_id = ? WHERE cache_key = ?`, keyID, entry.CacheKey)
+				if err != nil {
+					return err
+				}
+				stamped++
+				continue
+			}
+		}
+		// There's no telling which key it was signed with, so it can't count as tampering
+		if _, err := cacheDB.Exec(`DELETE FROM scripts WHERE cache_key = ?`, entry.CacheKey); err != nil {
+			return err
+		}
+		dropped++
+	}
+	if stamped > 0 || dropped > 0 {
+		log.Printf("Recorded the signing key of %d cached scripts, dropped %d that didn't verify\n", stamped, dropped)
+	}
+	return nil
+}
+
+// logSecurityEvent writes a single JSON line that log shippers can pick out by its "security_event" key
+func logSecurityEvent(event string, fields map[string]string) {
+	record := map[string]string{
+		"security_event": event,
+		"time":           time.Now().UTC().Format(time.RFC3339),
+	}
+	for key, value := range fields {
+		record[key] = value
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("SECURITY EVENT %s: %v\n", event, fields)
+		return
+	}
+	log.Printf("SECURITY EVENT %s\n", data)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// startCacheJanitor periodically evicts the least recently used cached scripts until the
+// cache is within max_cache_entries and max_cache_bytes
+func startCacheJanitor() {
+	go runPeriodically(func() time.Duration { return currentConfig().CacheJanitorInterval }, runCacheJanitor)
+}
+
+func runCacheJanitor() {
+	if expired, err := removeExpiredResults(); err != nil {
+		log.Printf("Failed to remove expired results: %v\n", err)
+	} else if expired > 0 {
+		log.Printf("Removed %d expired cached results\n", expired)
+	}
+
+	config := currentConfig()
+	if config.MaxCacheEntries <= 0 && config.MaxCacheBytes <= 0 {
+		return
+	}
+	// Another extension sharing the cache may be evicting from the same snapshot
+	var evicted int
+	err := withCacheLock(config.CacheDir, func() error {
+		var err error
+		evicted, err = evictCacheEntries(config.MaxCacheEntries, config.MaxCacheBytes)
+		return err
+	})
+	if err != nil {
+		log.Printf("Cache eviction failed: %v\n", err)
+		return
+	}
+	if evicted > 0 {
+		log.Printf("Evicted %d cached scripts to stay within cache limits\n", evicted)
+	}
+}
+
+// evictCacheEntries removes entries in least recently used order until no more than maxEntries
+// remain and their contents total no more than maxBytes. A limit of 0 disables that check.
+func evictCacheEntries(maxEntries int, maxBytes int64) (int, error) {
+	rows, err := cacheDB.Query(`SELECT cache_key, script_name, length(contents) FROM scripts ORDER BY last_accessed DESC`)
+	if err != nil {
+		return 0, err
+	}
+
+	type entrySize struct {
+		cacheKey   string
+		scriptName string
+		size       int64
+	}
+	var entries []entrySize
+	for rows.Next() {
+		var entry entrySize
+		if err := rows.Scan(&entry.cacheKey, &entry.scriptName, &entry.size); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Keep the most recently used entries that fit, evict the rest
+	var keptBytes int64
+	evicted := 0
+	for i, entry := range entries {
+		overEntries := maxEntries > 0 && i >= maxEntries
+		overBytes := maxBytes > 0 && keptBytes+entry.size > maxBytes
+		if !overEntries && !overBytes {
+			keptBytes += entry.size
+			continue
+		}
+		if _, err := cacheDB.Exec(`DELETE FROM scripts WHERE cache_key = ?`, entry.cacheKey); err != nil {
+			return evicted, err
+		}
+		log.Printf("Evicted cached script %s (%d bytes)\n", entry.scriptName, entry.size)
+		evicted++
+	}
+	return evicted, nil
+}
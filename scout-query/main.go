@@ -51,27 +51,62 @@ func main() {
 	}
 
 	// Fetch the Scout Config
-	_, err = fetchScoutConfig(*scoutConf) // Assigns to scoutConfig variable
+	scoutConfig, err := fetchScoutConfig(*scoutConf)
 	if err != nil {
 		log.Fatalf("failed to fetch scout config: %v\n", err)
 	}
+	setConfig(scoutConfig)
+
+	// Build the shared HTTP client for the script server
+	client, err := newHTTPClient(scoutConfig)
+	if err != nil {
+		log.Fatalf("failed to configure http client: %v\n", err)
+	}
+	httpClient.Store(client)
+
+	// Track health of the script servers for failover
+	scriptServers.Store(newServerPool(scoutConfig))
 
 	// Ensure cache directory exists
 	if err := ensureCacheDir(scoutConfig.CacheDir); err != nil {
 		log.Fatalf("failed to ensure cache directory: %v\n", err)
 	}
 
-	// Ensure cache database exists
-	if err := ensureCacheDB(scoutConfig.CacheDir); err != nil {
-		log.Fatalf("failed to ensure cache database: %v\n", err)
+	// Load the cache encryption key before anything is read from or written to the cache
+	if err := initCacheEncryption(scoutConfig); err != nil {
+		log.Fatalf("failed to configure cache encryption: %v\n", err)
+	}
+
+	// Open the cache database, migrating any file based cache into it
+	if err := openCacheDB(scoutConfig.CacheDir); err != nil {
+		log.Fatalf("failed to open cache database: %v\n", err)
 	}
+	defer cacheDB.Close()
+
+	// Keep the cache within its configured limits
+	startCacheJanitor()
+
+	// Quarantine cache entries that have been tampered with
+	startIntegrityScanner()
+
+	// Warm the cache with the scripts in the prefetch list
+	startPrefetcher()
+
+	// Pick up config changes without a restart
+	startConfigWatcher(*scoutConf)
 
 	// Register the plugins
 	scoutQuickExec := table.NewPlugin("scout_exec", QuickExecColumns(), ScoutQuickExecGenerate)
-	scoutScriptCache := table.NewPlugin("scout_cache", CachedScriptsColumns(), ScoutScriptCacheGenerate)
+	scoutScriptCache := newWritableTablePlugin("scout_cache", CachedScriptsColumns(), ScoutScriptCacheGenerate, ScoutScriptCacheDelete, cacheOpsAllowed)
+	scoutCacheOps := table.NewPlugin("scout_cache_ops", CacheOpsColumns(), ScoutCacheOpsGenerate)
+	scoutMetrics := table.NewPlugin("scout_metrics", MetricsColumns(), ScoutMetricsGenerate)
+	scoutConfigTable := table.NewPlugin("scout_config", ConfigColumns(), ScoutConfigGenerate)
 
 	server.RegisterPlugin(scoutQuickExec)
 	server.RegisterPlugin(scoutScriptCache)
+	server.RegisterPlugin(scoutCacheOps)
+	server.RegisterPlugin(scoutMetrics)
+	server.RegisterPlugin(scoutConfigTable)
 
 	if err := server.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error running extension: %v\n", err)
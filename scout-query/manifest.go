@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestEntry describes one script published by the script server
+type ManifestEntry struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Variants    []string `json:"variants"` // Variant subdirectories the script exists under, see getScriptVariants
+
+	// How long results of the script may be reused, see getResultTTL. 0 disables result caching.
+	ResultTTLSeconds float64 `json:"result_ttl_seconds,omitempty"`
+
+	// Named parameters the script takes instead of positional args, see parseScriptInput
+	Params        []ScriptParam `json:"params,omitempty"`
+	ParamDelivery string        `json:"param_delivery,omitempty"` // paramDeliveryEnv (default) or paramDeliveryStdin
+}
+
+// Manifest is the signed list of scripts served from <server>/manifest.json
+type Manifest struct {
+	Scripts []ManifestEntry `json:"scripts"`
+}
+
+// How long a failed manifest fetch is remembered before the server is asked again
+const manifestRetryBackoff = 30 * time.Second
+
+var (
+	manifestMutex     sync.Mutex
+	cachedManifest    *Manifest
+	manifestFetchedAt time.Time
+	manifestErr       error // Last fetch error, retried after manifestRetryBackoff
+	manifestFailedAt  time.Time
+)
+
+// getManifest returns the script server's manifest, refetching it once it is older than the cache window.
+// A failed fetch is remembered for manifestRetryBackoff, so lookups don't hit the server on every query
+// while it is down but the manifest comes back soon after the server does.
+func getManifest() (*Manifest, error) {
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+
+	if cachedManifest != nil && time.Since(manifestFetchedAt) < currentConfig().CacheWindow {
+		return cachedManifest, nil
+	}
+	if manifestErr != nil && time.Since(manifestFailedAt) < manifestRetryBackoff {
+		if cachedManifest != nil {
+			return cachedManifest, nil
+		}
+		return nil, manifestErr
+	}
+
+	manifest, err := fetchManifest()
+	if err != nil {
+		manifestErr = err
+		manifestFailedAt = time.Now()
+		if cachedManifest != nil {
+			log.Printf("Failed to refresh manifest, using previous copy: %v\n", err)
+			return cachedManifest, nil
+		}
+		return nil, err
+	}
+
+	cachedManifest = manifest
+	manifestFetchedAt = time.Now()
+	manifestErr = nil
+	return manifest, nil
+}
+
+// fetchManifest downloads and verifies the manifest from the first healthy script server
+func fetchManifest() (*Manifest, error) {
+	pool := scriptServers.Load()
+	servers := pool.available()
+	if len(servers) == 0 {
+		return nil, &serverError{fmt.Errorf("all script servers are unhealthy")}
+	}
+
+	var lastErr error
+	for _, serverURL := range servers {
+		manifest, err := fetchManifestFromServer(serverURL)
+		if err == nil {
+			pool.recordSuccess(serverURL)
+			return manifest, nil
+		}
+		if _, ok := err.(*serverError); ok {
+			pool.recordFailure(serverURL, err)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func fetchManifestFromServer(serverURL string) (*Manifest, error) {
+	manifestURL := strings.TrimRight(serverURL, "/") + "/manifest.json"
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, &serverError{fmt.Errorf("failed to fetch manifest: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to fetch manifest: received status code %d", resp.StatusCode)
+		if resp.StatusCode >= 500 {
+			return nil, &serverError{err}
+		}
+		return nil, err
+	}
+
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	// The manifest is signed the same way as scripts
+	signature, err := hex.DecodeString(resp.Header.Get("X-Signature"))
+	if err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("manifest has no valid signature header")
+	}
+	if _, err := verifyScriptSignature(body, signature, currentConfig().PublicKey); err != nil {
+		return nil, fmt.Errorf("manifest signature verification failed: %v", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	return &manifest, nil
+}
+
+// resetManifest drops the in-memory manifest so the next lookup fetches it again
+func resetManifest() {
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+
+	cachedManifest = nil
+	manifestErr = nil
+	manifestFetchedAt = time.Time{}
+	manifestFailedAt = time.Time{}
+}
+
+// findManifestEntry looks up a script in the manifest. The error is set when the manifest
+// couldn't be fetched, so a script missing from it can be told apart from no manifest at all.
+func findManifestEntry(scriptName string) (ManifestEntry, bool, error) {
+	manifest, err := getManifest()
+	if err != nil {
+		return ManifestEntry{}, false, err
+	}
+	for _, entry := range manifest.Scripts {
+		if entry.Name == scriptName {
+			return entry, true, nil
+		}
+	}
+	return ManifestEntry{}, false, nil
+}
+
+// isGlobPattern reports whether a script name contains path.Match wildcards
+func isGlobPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// matchManifest returns the names of scripts in the manifest matching a glob that have a
+// variant this endpoint can run
+func matchManifest(manifest *Manifest, pattern string) []string {
+	variants := make(map[string]bool)
+	for _, variant := range getScriptVariants() {
+		variants[variant] = true
+	}
+
+	var names []string
+	for _, entry := range manifest.Scripts {
+		if ok, _ := path.Match(pattern, entry.Name); !ok {
+			continue
+		}
+		for _, variant := range entry.Variants {
+			if variants[variant] {
+				names = append(names, entry.Name)
+				break
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// metric is a counter reported by the scout_metrics table
+type metric struct {
+	name        string
+	description string
+	value       atomic.Int64
+}
+
+func newMetric(name, description string) *metric {
+	m := &metric{name: name, description: description}
+	allMetrics = append(allMetrics, m)
+	return m
+}
+
+func (m *metric) inc() {
+	m.value.Add(1)
+}
+
+// Every metric in the order it is reported, filled in by newMetric
+var allMetrics []*metric
+
+var (
+	metricScriptRequests     = newMetric("script_requests", "Calls to load a script, from queries and prefetch")
+	metricScriptFetches      = newMetric("script_fetches", "Script loads actually performed, one per group of coalesced requests")
+	metricCoalescedRequests  = newMetric("coalesced_requests", "Requests that shared the result of a concurrent load of the same script")
+	metricExecutionsQueued   = newMetric("executions_queued", "scout_exec runs that had to wait for a slot under the concurrency limits")
+	metricQueueRejections    = newMetric("queue_rejections", "scout_exec runs refused because the wait queue was full or the wait timed out")
+	metricThrottleDeferrals  = newMetric("throttle_deferrals", "scout_exec runs that waited for the host load to drop")
+	metricThrottleRejections = newMetric("throttle_rejections", "scout_exec runs refused because the host was over a throttle threshold")
+	metricPolicyDenials      = newMetric("policy_denials", "scout_exec runs refused by allow_scripts, deny_scripts or script_policy")
+)
+
+// ScoutMetricsGenerate reports the extension's counters
+func ScoutMetricsGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	var results []map[string]string
+	for _, m := range allMetrics {
+		results = append(results, map[string]string{
+			"name":        m.name,
+			"value":       strconv.FormatInt(m.value.Load(), 10),
+			"description": m.description,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Offline policies for running cached scripts when no script server is reachable
+const (
+	offlineStrict              = "strict"                // Never run without the server confirming the script
+	offlineAllowStale          = "allow_stale"           // Run cached scripts confirmed within offline_max_stale_hours
+	offlineAlwaysAllowVerified = "always_allow_verified" // Run any cached script whose signature verifies
+)
+
+// allowOffline decides whether a cached script whose signature has already been verified
+// may run while the script servers are unreachable
+func allowOffline(meta CacheMeta) bool {
+	config := currentConfig()
+	switch config.OfflinePolicy {
+	case offlineAlwaysAllowVerified:
+		return true
+	case offlineAllowStale:
+		age := time.Since(meta.CacheTime)
+		if age <= config.OfflineMaxStale {
+			return true
+		}
+		log.Printf("Cached script %s is %s old, beyond the offline limit of %s\n", meta.ScriptName, age.Round(time.Second), config.OfflineMaxStale)
+		return false
+	default:
+		return false
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/osquery/osquery-go"
+	osquerygen "github.com/osquery/osquery-go/gen/osquery"
+)
+
+var errNoScoutSection = errors.New("no 'scout' section in config")
+
+// The last answer from osquery's config plugin, reused by config reloads, see loadOsqueryScoutOptions
+var (
+	osqueryOptionsMutex     sync.Mutex
+	osqueryOptions          map[string]interface{}
+	osqueryOptionsSource    string
+	osqueryOptionsErr       error
+	osqueryOptionsFetchedAt time.Time
+)
+
+// loadOsqueryScoutOptions returns the result of fetchOsqueryScoutOptions. genConfig makes plugins
+// such as tls fetch the config from the fleet manager, which shouldn't happen on every config
+// reload, so for any plugin but filesystem the result is reused until it is older than
+// osquery_config_refresh_seconds.
+func loadOsqueryScoutOptions(client *osquery.ExtensionManagerClient) (map[string]interface{}, string, error) {
+	pluginName, err := getOsqueryConfigPlugin(client)
+	if err != nil {
+		return nil, "", err
+	}
+	if pluginName == "filesystem" {
+		// Only reads the local config files
+		return fetchOsqueryScoutOptions(client, pluginName)
+	}
+
+	osqueryOptionsMutex.Lock()
+	defer osqueryOptionsMutex.Unlock()
+
+	if !osqueryOptionsFetchedAt.IsZero() {
+		refresh := currentConfig().OsqueryConfigRefresh
+		if refresh <= 0 || time.Since(osqueryOptionsFetchedAt) < refresh {
+			return osqueryOptions, osqueryOptionsSource, osqueryOptionsErr
+		}
+	}
+
+	osqueryOptions, osqueryOptionsSource, osqueryOptionsErr = fetchOsqueryScoutOptions(client, pluginName)
+	osqueryOptionsFetchedAt = time.Now()
+	return osqueryOptions, osqueryOptionsSource, osqueryOptionsErr
+}
+
+// Helper function to get the name of osquery's active config plugin
+func getOsqueryConfigPlugin(client *osquery.ExtensionManagerClient) (string, error) {
+	rows, err := client.QueryRows("SELECT value FROM osquery_flags WHERE name = 'config_plugin';")
+	if err != nil {
+		return "", fmt.Errorf("failed to query osquery_flags for config_plugin: %v", err)
+	}
+	if len(rows) > 0 && rows[0]["value"] != "" {
+		return rows[0]["value"], nil
+	}
+	return "filesystem", nil
+}
+
+// fetchOsqueryScoutOptions asks osquery's active config plugin (filesystem, tls or one
+// provided by another extension) for the config it serves and merges the scout sections of
+// every source in it, the same way osquery merges options. This reaches settings delivered by
+// a fleet manager that never touch the local disk. Returns the merged section and a
+// description of where it came from.
+func fetchOsqueryScoutOptions(client *osquery.ExtensionManagerClient, pluginName string) (map[string]interface{}, string, error) {
+	resp, err := client.Call("config", pluginName, osquerygen.ExtensionPluginRequest{"action": "genConfig"})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to call config plugin %s: %v", pluginName, err)
+	}
+	if resp.Status != nil && resp.Status.Code != 0 {
+		return nil, "", fmt.Errorf("config plugin %s: %s", pluginName, resp.Status.Message)
+	}
+
+	// Each response row maps source names to the config JSON from that source
+	sources := make(map[string]string)
+	for _, row := range resp.Response {
+		for source, data := range row {
+			sources[source] = data
+		}
+	}
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var merged map[string]interface{}
+	var found []string
+	for _, name := range names {
+		var configMap map[string]interface{}
+		if err := json.Unmarshal([]byte(sources[name]), &configMap); err != nil {
+			return nil, "", fmt.Errorf("failed to parse config from %s: %v", name, err)
+		}
+		scoutOptions, ok := configMap["scout"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if merged == nil {
+			merged = make(map[string]interface{})
+		}
+		for key, value := range scoutOptions {
+			merged[key] = value
+		}
+		found = append(found, name)
+	}
+	if merged == nil {
+		return nil, "", errNoScoutSection
+	}
+
+	return merged, fmt.Sprintf("osquery config plugin %s (%v)", pluginName, found), nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parameter types a script can declare in the manifest
+const (
+	paramString  = "string"
+	paramInteger = "integer"
+	paramNumber  = "number"
+	paramBoolean = "boolean"
+)
+
+// How named parameters are handed to a script
+const (
+	paramDeliveryEnv   = "env"   // One SCOUT_PARAM_<NAME> environment variable per parameter
+	paramDeliveryStdin = "stdin" // A JSON object of all parameters on stdin
+)
+
+// Prefix of the environment variables parameters are delivered in
+const paramEnvPrefix = "SCOUT_PARAM_"
+
+// ScriptParam is a named parameter a script declares in the manifest
+type ScriptParam struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"` // paramString (default), paramInteger, paramNumber or paramBoolean
+	Default  interface{} `json:"default,omitempty"`
+	Required bool        `json:"required,omitempty"`
+	Values   []string    `json:"values,omitempty"` // Allowed values of a string parameter, empty allows any
+}
+
+// scriptInput is what a script is run with: positional arguments for scripts that declare no
+// parameters, or validated named parameters
+type scriptInput struct {
+	Raw      string                 // args as given in the query
+	Args     []string               // Positional arguments
+	Params   map[string]interface{} // Named parameter values, nil for scripts without parameters
+	Delivery string                 // paramDeliveryEnv or paramDeliveryStdin
+}
+
+// inputError is args that don't fit what the script accepts, reported as an "invalid_args" row
+type inputError struct {
+	reason string
+}
+
+func (e *inputError) Error() string {
+	return e.reason
+}
+
+// parseScriptInput turns the args of a query into the input for a script. Scripts that declare
+// params in the manifest take args as a JSON object, checked against the declared types, with
+// defaults filled in. Other scripts take positional arguments as before. Every run fails while
+// the manifest can't be fetched, rather than being passed on unchecked.
+func parseScriptInput(scriptName string, argsList []string) (scriptInput, error) {
+	raw := strings.Join(argsList, " ")
+	input := scriptInput{Raw: raw}
+	isObject := strings.HasPrefix(strings.TrimSpace(raw), "{")
+
+	entry, _, err := findManifestEntry(scriptName)
+	if err != nil {
+		// Without the manifest there's no telling whether the script declares parameters, so
+		// positional args could reach a script that expects none and required ones go unchecked.
+		// getManifest keeps serving the last good copy, so this only happens before the first fetch.
+		return input, fmt.Errorf("manifest unavailable, can't check the parameters of %s: %v", scriptName, err)
+	}
+	if len(entry.Params) == 0 {
+		if isObject {
+			return input, &inputError{fmt.Sprintf("script %s declares no parameters in the manifest, args must be positional", scriptName)}
+		}
+		input.Args = parseArguments(raw)
+		return input, nil
+	}
+
+	if strings.TrimSpace(raw) != "" && !isObject {
+		return input, &inputError{fmt.Sprintf("script %s takes named parameters, args must be a JSON object", scriptName)}
+	}
+	given := make(map[string]interface{})
+	if isObject {
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&given); err != nil {
+			return input, &inputError{fmt.Sprintf("args is not a valid JSON object: %v", err)}
+		}
+	}
+
+	input.Delivery = entry.ParamDelivery
+	if input.Delivery == "" {
+		input.Delivery = paramDeliveryEnv
+	}
+	if input.Delivery != paramDeliveryEnv && input.Delivery != paramDeliveryStdin {
+		return input, fmt.Errorf("manifest entry for %s has unknown param_delivery %q", scriptName, input.Delivery)
+	}
+
+	input.Params = make(map[string]interface{})
+	declared := make(map[string]bool)
+	for _, param := range entry.Params {
+		declared[param.Name] = true
+		value, ok := given[param.Name]
+		if !ok || value == nil {
+			if param.Required {
+				return input, &inputError{fmt.Sprintf("missing required parameter %s", param.Name)}
+			}
+			if param.Default == nil {
+				continue
+			}
+			value = param.Default
+		}
+		typed, err := checkParamValue(param, value)
+		if err != nil {
+			return input, &inputError{fmt.Sprintf("parameter %s: %v", param.Name, err)}
+		}
+		input.Params[param.Name] = typed
+	}
+
+	var unknown []string
+	for name := range given {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return input, &inputError{fmt.Sprintf("script %s has no parameters %s", scriptName, strings.Join(unknown, ", "))}
+	}
+	return input, nil
+}
+
+// Helper function to check a parameter value against its declared type, returning it as the
+// Go type the script will receive it as
+func checkParamValue(param ScriptParam, value interface{}) (interface{}, error) {
+	switch param.Type {
+	case "", paramString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string")
+		}
+		if len(param.Values) > 0 && !containsString(param.Values, s) {
+			return nil, fmt.Errorf("must be one of %s, got %q", strings.Join(param.Values, ", "), s)
+		}
+		return s, nil
+	case paramInteger:
+		n, err := paramNumberValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer, got %s", n)
+		}
+		return i, nil
+	case paramNumber:
+		n, err := paramNumberValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number, got %s", n)
+		}
+		return f, nil
+	case paramBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("manifest declares unknown type %q", param.Type)
+}
+
+// Helper function to get the text of a JSON number from the query (json.Number) or a manifest default (float64)
+func paramNumberValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("not a number")
+}
+
+// values returns the arguments or parameter values as strings, for checks that apply to both
+func (input scriptInput) values() []string {
+	if input.Params == nil {
+		return input.Args
+	}
+	var values []string
+	for _, name := range sortedKeys(input.Params) {
+		values = append(values, formatParamValue(input.Params[name]))
+	}
+	return values
+}
+
+// Helper function to format a parameter value for an environment variable
+func formatParamValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// Helper function to name the environment variable a parameter is delivered in
+func paramEnvName(name string) string {
+	var b strings.Builder
+	b.WriteString(paramEnvPrefix)
+	for _, c := range strings.ToUpper(name) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// deliver hands named parameters to the command, the same way on every platform
+func (input scriptInput) deliver(cmd *exec.Cmd) error {
+	if input.Params == nil {
+		return nil
+	}
+	switch input.Delivery {
+	case paramDeliveryStdin:
+		payload, err := json.Marshal(input.Params)
+		if err != nil {
+			return err
+		}
+		cmd.Stdin = bytes.NewReader(payload)
+	default:
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		for _, name := range sortedKeys(input.Params) {
+			cmd.Env = append(cmd.Env, paramEnvName(name)+"="+formatParamValue(input.Params[name]))
+		}
+	}
+	return nil
+}
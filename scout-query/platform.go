@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// genericVariant is the variant label used for the OS-less /scripts/<file> route
+const genericVariant = "generic"
+
+var (
+	osReleaseOnce sync.Once
+	osReleaseID   string
+	osReleaseVer  string
+)
+
+// Read the distro ID and VERSION_ID from /etc/os-release (Linux only)
+func getLinuxDistro() (id string, version string) {
+	osReleaseOnce.Do(func() {
+		if runtime.GOOS != "linux" {
+			return
+		}
+		for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+			file, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+				if !found {
+					continue
+				}
+				value = strings.Trim(value, `"'`)
+				switch key {
+				case "ID":
+					osReleaseID = sanitizeVariantPart(value)
+				case "VERSION_ID":
+					osReleaseVer = sanitizeVariantPart(value)
+				}
+			}
+			file.Close()
+			return
+		}
+	})
+	return osReleaseID, osReleaseVer
+}
+
+// Lowercase the value and drop anything that isn't safe to use as a URL path segment
+func sanitizeVariantPart(value string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(value) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.' || c == '-' || c == '_' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// getScriptVariants returns the ordered list of server subdirectories to try for a script,
+// from most to least specific. A script built for the architecture wins over a distro
+// specific one. For example on Ubuntu 22.04 amd64:
+//
+//	linux/amd64, linux/ubuntu/22.04, linux/ubuntu, linux, generic
+func getScriptVariants() []string {
+	osDir := getOSSubDir()
+	variants := []string{osDir + "/" + runtime.GOARCH}
+
+	if osDir == "linux" {
+		id, version := getLinuxDistro()
+		if id != "" {
+			if version != "" {
+				variants = append(variants, osDir+"/"+id+"/"+version)
+			}
+			variants = append(variants, osDir+"/"+id)
+		}
+	}
+
+	variants = append(variants, osDir, genericVariant)
+	return variants
+}
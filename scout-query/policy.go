@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ScriptPolicy constrains how one script (or glob of scripts) may be run through scout_exec
+type ScriptPolicy struct {
+	ArgsPattern   *regexp.Regexp `json:"args_pattern"`   // Every argument must match in full, nil allows any
+	ArgsEnum      []string       `json:"args_enum"`      // Every argument must be one of these, empty allows any
+	MaxConcurrent int            `json:"max_concurrent"` // Runs of the script at once, see scriptConcurrencyLimit
+}
+
+// policyError is a run refused by the endpoint policy, reported as a "denied" row
+type policyError struct {
+	reason string
+}
+
+func (e *policyError) Error() string {
+	return e.reason
+}
+
+// lookupByScriptName finds the setting for a script in a map keyed by script names and globs:
+// an exact name first, else the longest glob that matches. Globs of the same length are
+// tried in lexical order, so the same script always gets the same setting.
+func lookupByScriptName[V any](settings map[string]V, scriptName string) (V, bool) {
+	if value, ok := settings[scriptName]; ok {
+		return value, true
+	}
+
+	bestPattern := ""
+	var bestValue V
+	for pattern, value := range settings {
+		if !isGlobPattern(pattern) || len(pattern) < len(bestPattern) {
+			continue
+		}
+		if len(pattern) == len(bestPattern) && pattern > bestPattern {
+			continue
+		}
+		if ok, _ := path.Match(pattern, scriptName); ok {
+			bestPattern, bestValue = pattern, value
+		}
+	}
+	return bestValue, bestPattern != ""
+}
+
+// Helper function to check a script name against a list of names and globs
+func matchesScriptList(patterns []string, scriptName string) bool {
+	for _, pattern := range patterns {
+		if pattern == scriptName {
+			return true
+		}
+		if ok, _ := path.Match(pattern, scriptName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScriptPolicy decides whether scout_exec may run a script with the given args under the
+// allow_scripts, deny_scripts and script_policy settings. A deny match wins over an allow
+// match, and an empty allow list allows every script that isn't denied.
+func checkScriptPolicy(config *ScoutConfig, scriptName string, input scriptInput) error {
+	if matchesScriptList(config.DenyScripts, scriptName) {
+		return &policyError{fmt.Sprintf("script %s is denied by deny_scripts", scriptName)}
+	}
+	if len(config.AllowScripts) > 0 && !matchesScriptList(config.AllowScripts, scriptName) {
+		return &policyError{fmt.Sprintf("script %s is not in allow_scripts", scriptName)}
+	}
+
+	policy, ok := lookupByScriptName(config.ScriptPolicies, scriptName)
+	if !ok {
+		return nil
+	}
+	// Checked after parsing, as the script will receive them
+	for _, arg := range input.values() {
+		if policy.ArgsPattern != nil && !policy.ArgsPattern.MatchString(arg) {
+			return &policyError{fmt.Sprintf("argument %q to %s does not match args_pattern %s", arg, scriptName, policy.ArgsPattern)}
+		}
+		if len(policy.ArgsEnum) > 0 && !containsString(policy.ArgsEnum, arg) {
+			return &policyError{fmt.Sprintf("argument %q to %s is not one of args_enum %s", arg, scriptName, strings.Join(policy.ArgsEnum, ", "))}
+		}
+	}
+	return nil
+}
+
+// Helper function to check whether a list of strings contains a value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"log"
 	"os/exec"
 )
@@ -19,19 +19,11 @@ func New() *PowerShell {
 	}
 }
 
-func (p *PowerShell) execute(scriptPath string, args ...string) (stdOut string, stdErr string, err error) {
+// command builds the command to run a script file, killed when ctx is done
+func (p *PowerShell) command(ctx context.Context, scriptPath string, args ...string) *exec.Cmd {
 	// Combine the script path and additional arguments
 	allArgs := append([]string{"-NoProfile", "-ExecutionPolicy", "Bypass", "-NonInteractive", "-File", scriptPath}, args...)
 
 	log.Printf("Executing the following command: %v %v", p.powerShell, allArgs)
-	cmd := exec.Command(p.powerShell, allArgs...)
-
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err = cmd.Run()
-	stdOut, stdErr = stdout.String(), stderr.String()
-	return stdOut, stdErr, err
+	return exec.CommandContext(ctx, p.powerShell, allArgs...)
 }
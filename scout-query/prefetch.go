@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// prefetchStatus records the outcome of the last prefetch of a script
+type prefetchStatus struct {
+	Status      string // "pending", "ok" or "failed"
+	Error       string
+	LastAttempt time.Time
+}
+
+var (
+	prefetchMutex  sync.Mutex
+	prefetchStates = make(map[string]prefetchStatus)
+)
+
+// startPrefetcher fetches, verifies and caches the scripts in the prefetch list right away
+// and then again every interval, so live queries don't wait on first-time downloads.
+// With an interval of 0 the list is only fetched at startup and when a reload changes it.
+func startPrefetcher() {
+	if config := currentConfig(); config.PrefetchInterval <= 0 && len(config.Prefetch) > 0 {
+		go runPrefetch(config.Prefetch)
+	}
+	go runPeriodically(func() time.Duration { return currentConfig().PrefetchInterval }, func() {
+		if patterns := currentConfig().Prefetch; len(patterns) > 0 {
+			runPrefetch(patterns)
+		}
+	})
+}
+
+func runPrefetch(patterns []string) {
+	var names []string
+	for _, pattern := range patterns {
+		if !isGlobPattern(pattern) {
+			names = append(names, pattern)
+			continue
+		}
+		manifest, err := getManifest()
+		if err != nil {
+			log.Printf("Failed to expand prefetch pattern %s: %v\n", pattern, err)
+			continue
+		}
+		matches := matchManifest(manifest, pattern)
+		if len(matches) == 0 {
+			log.Printf("Prefetch pattern %s matched no scripts in the manifest\n", pattern)
+		}
+		names = append(names, matches...)
+	}
+
+	for _, name := range names {
+		if getPrefetchStatus(name).Status == "" {
+			setPrefetchStatus(name, "pending", nil)
+		}
+	}
+
+	for _, name := range names {
+		_, err := getScript(name, true)
+		if err != nil {
+			log.Printf("Failed to prefetch script %s: %v\n", name, err)
+			setPrefetchStatus(name, "failed", err)
+			continue
+		}
+		setPrefetchStatus(name, "ok", nil)
+	}
+}
+
+func setPrefetchStatus(name string, status string, err error) {
+	prefetchMutex.Lock()
+	defer prefetchMutex.Unlock()
+
+	state := prefetchStatus{Status: status, LastAttempt: time.Now()}
+	if status == "pending" {
+		state.LastAttempt = time.Time{}
+	}
+	if err != nil {
+		state.Error = err.Error()
+	}
+	prefetchStates[name] = state
+}
+
+func getPrefetchStatus(name string) prefetchStatus {
+	prefetchMutex.Lock()
+	defer prefetchMutex.Unlock()
+	return prefetchStates[name]
+}
+
+// Snapshot of the prefetch status of every script in the prefetch list
+func prefetchStatusSnapshot() map[string]prefetchStatus {
+	prefetchMutex.Lock()
+	defer prefetchMutex.Unlock()
+
+	states := make(map[string]prefetchStatus, len(prefetchStates))
+	for name, state := range prefetchStates {
+		states[name] = state
+	}
+	return states
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// How often a paused periodic task checks whether a reload has given it an interval
+const idleTaskPoll = time.Minute
+
+// Settings tied to the open cache database, which only take effect after a restart
+var restartOnlySettings = []string{"CacheDir", "EncryptCache", "CacheKeySource", "CacheKeyFile"}
+
+// Last restart-only change logged by applyConfig
+var pendingRestartWarning string
+
+// Settings that are secrets, logged only as changed
+var secretSettings = map[string]bool{"BearerToken": true, "APIKey": true}
+
+// runPeriodically calls fn straight away and then every interval(). The interval is read again
+// each round so config reloads take effect; while it is 0 the task is paused.
+func runPeriodically(interval func() time.Duration, fn func()) {
+	for {
+		wait := interval()
+		if wait <= 0 {
+			time.Sleep(idleTaskPoll)
+			continue
+		}
+		fn()
+		time.Sleep(wait)
+	}
+}
+
+// startConfigWatcher re-reads the scout config every config_reload_interval_seconds and applies
+// it if it changed. fetchScoutConfig asks osquery for the config path again each time, so a
+// moved config file is picked up as well.
+func startConfigWatcher(scoutConfFlag string) {
+	go func() {
+		// The config was just loaded at startup
+		time.Sleep(currentConfig().ConfigReloadInterval)
+		runPeriodically(func() time.Duration { return currentConfig().ConfigReloadInterval }, func() {
+			if err := reloadConfig(scoutConfFlag); err != nil {
+				log.Printf("Config reload failed, keeping the current config: %v\n", err)
+			}
+		})
+	}()
+}
+
+func reloadConfig(scoutConfFlag string) error {
+	config, err := fetchScoutConfig(scoutConfFlag)
+	if err != nil {
+		return err
+	}
+	return applyConfig(config)
+}
+
+// applyConfig swaps in a new config, rebuilding the HTTP client and server pool when their
+// settings changed. Executions and downloads already running finish with the config, client
+// and servers they started with.
+func applyConfig(config ScoutConfig) error {
+	old := *currentConfig()
+
+	var pending []string
+	for _, name := range restartOnlySettings {
+		oldValue := reflect.ValueOf(&old).Elem().FieldByName(name)
+		newValue := reflect.ValueOf(&config).Elem().FieldByName(name)
+		if !reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			pending = append(pending, fmt.Sprintf("%s %v -> %v", name, oldValue.Interface(), newValue.Interface()))
+			newValue.Set(oldValue)
+		}
+	}
+	// Warn once per change rather than on every poll
+	if warning := strings.Join(pending, "; "); warning != pendingRestartWarning {
+		if warning != "" {
+			log.Printf("Config changes require a restart of the extension to take effect: %s\n", warning)
+		}
+		pendingRestartWarning = warning
+	}
+
+	changes := diffConfigs(old, config)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// Build the new client before changing anything, so a bad reload leaves everything as it was
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure http client: %v", err)
+	}
+
+	setConfig(config)
+	httpClient.Store(client)
+
+	// Keep the servers' health unless the servers or breaker settings changed
+	if !reflect.DeepEqual(old.ServerURLs, config.ServerURLs) || old.BreakerThreshold != config.BreakerThreshold ||
+		old.BreakerCooldown != config.BreakerCooldown {
+		scriptServers.Store(newServerPool(config))
+	}
+	// The manifest may come from a different server or need a different key to verify
+	if !reflect.DeepEqual(old.ServerURLs, config.ServerURLs) || old.PublicKey != config.PublicKey {
+		resetManifest()
+	}
+	if old.PublicKey != config.PublicKey && cacheDB != nil {
+		if err := reconcileSigningKey(config.PublicKey); err != nil {
+			log.Printf("Failed to drop cached scripts signed with the old public key: %v\n", err)
+		}
+	}
+	if !reflect.DeepEqual(old.Prefetch, config.Prefetch) && len(config.Prefetch) > 0 {
+		go runPrefetch(config.Prefetch)
+	}
+
+	log.Printf("Reloaded scout config from %s: %s\n", config.SourcePath, strings.Join(changes, "; "))
+	return nil
+}
+
+// diffConfigs describes each setting that differs between two configs
+func diffConfigs(old, new ScoutConfig) []string {
+	var changes []string
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+	configType := oldValue.Type()
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		before := oldValue.Field(i).Interface()
+		after := newValue.Field(i).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		switch {
+		case secretSettings[field.Name]:
+			changes = append(changes, fmt.Sprintf("%s changed", field.Name))
+		case field.Name == "PublicKey":
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", field.Name, keyFingerprint(old.PublicKey), keyFingerprint(new.PublicKey)))
+		default:
+			changes = append(changes, fmt.Sprintf("%s %v -> %v", field.Name, before, after))
+		}
+	}
+	return changes
+}
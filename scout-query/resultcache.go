@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// getResultTTL returns how long results of a script may be reused: an exact name in the
+// result_cache config, else the longest matching glob there, else result_ttl_seconds from the
+// server's manifest. 0 means results are not cached.
+func getResultTTL(scriptName string) time.Duration {
+	if ttl, ok := lookupByScriptName(currentConfig().ResultCacheTTLs, scriptName); ok {
+		return ttl
+	}
+
+	if entry, ok, _ := findManifestEntry(scriptName); ok && entry.ResultTTLSeconds > 0 {
+		return time.Duration(entry.ResultTTLSeconds * float64(time.Second))
+	}
+	return 0
+}
+
+// getResultKey identifies a result by the script's hash and its input after parsing, so a new
+// script version or differently quoted but equal arguments behave as expected
+func getResultKey(scriptHash string, input scriptInput) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(scriptHash))
+	if input.Params != nil {
+		// Marshalled with sorted keys, so the order given in the query doesn't matter
+		params, _ := json.Marshal(input.Params)
+		hasher.Write([]byte{1})
+		hasher.Write(params)
+	}
+	for _, arg := range input.Args {
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(arg))
+	}
+	return "result:" + hex.EncodeToString(hasher.Sum(nil))
+}
+
+// Helper function to load an unexpired result from the execution_cache table
+func loadCachedResult(resultKey string) (ExecutionResult, bool) {
+	var result ExecutionResult
+	var consoleOut, errorOut []byte
+	var keyID string
+	row := cacheDB.QueryRow(`SELECT script, args, console_out, error_out, execution_time, duration, script_hash, status,
+		encryption_key_id FROM execution_cache WHERE job_id = ? AND expires_at > ?`, resultKey, time.Now().UnixNano())
+	err := row.Scan(&result.ScriptName, &result.Args, &consoleOut, &errorOut, &result.ExecutionTime, &result.Duration,
+		&result.ScriptHash, &result.Status, &keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, false
+	}
+	if err != nil {
+		log.Printf("Failed to load cached result: %v\n", err)
+		return result, false
+	}
+
+	// Output is encrypted like cached scripts when encrypt_cache is on
+	consoleOut, err = openCacheContents(resultKey, consoleOut, keyID)
+	if err == nil {
+		errorOut, err = openCacheContents(resultKey, errorOut, keyID)
+	}
+	if err != nil {
+		log.Printf("Discarding unreadable cached result: %v\n", err)
+		return result, false
+	}
+	result.ConsoleOut = string(consoleOut)
+	result.ErrorOut = string(errorOut)
+	result.JobID = resultKey
+	return result, true
+}
+
+// Helper function to store a completed result in the execution_cache table until the TTL expires
+func saveCachedResult(resultKey string, result ExecutionResult, ttl time.Duration) error {
+	consoleOut, keyID, err := sealCacheContents(resultKey, []byte(result.ConsoleOut))
+	if err != nil {
+		return err
+	}
+	errorOut, _, err := sealCacheContents(resultKey, []byte(result.ErrorOut))
+	if err != nil {
+		return err
+	}
+
+	_, err = cacheDB.Exec(`INSERT OR REPLACE INTO execution_cache (job_id, script, args, console_out, error_out,
+			execution_time, duration, script_hash, status, encryption_key_id, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		resultKey, result.ScriptName, result.Args, consoleOut, errorOut, result.ExecutionTime, result.Duration,
+		result.ScriptHash, result.Status, keyID, time.Now().Add(ttl).UnixNano())
+	return err
+}
+
+// Helper function to delete results whose TTL has passed
+func removeExpiredResults() (int64, error) {
+	result, err := cacheDB.Exec(`DELETE FROM execution_cache WHERE expires_at > 0 AND expires_at <= ?`, time.Now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -16,20 +17,26 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/kluctl/go-embed-python/python"
 	"github.com/osquery/osquery-go/plugin/table"
+	"golang.org/x/sync/singleflight"
 )
 
 // Script is a struct that represents a script that can be run on a target
 type Script struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Contents    []byte `json:"contents"`
-	Hash        string `json:"hash"`
-	Cached      bool   `json:"cached"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Contents    []byte    `json:"contents"`
+	Hash        string    `json:"hash"`
+	Cached      bool      `json:"cached"`
+	Variant     string    `json:"variant"`   // Server subdirectory the script was resolved from, e.g. "linux/amd64"
+	Server      string    `json:"server"`    // Script server that served the script
+	CachedAt    time.Time `json:"cached_at"` // When the cached copy was last confirmed by the server
+	Stale       bool      `json:"stale"`     // Cached copy run without revalidation because the server was unreachable
 }
 
 type ExecutionResult struct {
@@ -47,17 +54,12 @@ type ExecutionResult struct {
 }
 
 func ScoutQuickExecGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
-	scriptNames := processContextConstraints(queryContext, "script_name")
+	// script_name IN (...) and several args values arrive as one equals constraint per value
+	scriptNames := uniqueStrings(processContextConstraints(queryContext, "script_name"))
 	if len(scriptNames) == 0 {
 		return nil, fmt.Errorf("no script specified in the query")
 	}
-
-	if len(scriptNames) > 1 {
-		return nil, fmt.Errorf("only one script can be executed at a time")
-	}
-
-	scriptName := scriptNames[0]
-	argsList := processContextConstraints(queryContext, "args")
+	argsValues := uniqueStrings(processContextConstraints(queryContext, "args"))
 
 	// Log cache constraint if it exists
 	cacheList := processContextConstraints(queryContext, "from_cache")
@@ -65,26 +67,114 @@ func ScoutQuickExecGenerate(ctx context.Context, queryContext table.QueryContext
 	if len(cacheList) > 0 {
 		useCache = cacheList[0]
 	}
+
+	// Stored results are reused unless the query asks for a fresh run with result_from_cache = 'false'
+	reuse := processContextConstraints(queryContext, "result_from_cache")
+	reuseResults := len(reuse) == 0 || processBoolConstraint(reuse[0])
+
+	runs := fanOutRuns(scriptNames, argsValues)
+	if len(runs) == 1 {
+		return runQuickExec(runs[0].scriptName, runs[0].argsList, useCache, reuseResults)
+	}
+	return runFanOut(runs, currentConfig().FanOutWorkers, func(run scriptRun) ([]map[string]string, error) {
+		return runQuickExec(run.scriptName, run.argsList, useCache, reuseResults)
+	}), nil
+}
+
+// runQuickExec runs one script with one set of args for scout_exec and turns its output into rows
+func runQuickExec(scriptName string, argsList []string, useCache string, reuseResults bool) ([]map[string]string, error) {
 	cacheBool := processBoolConstraint(useCache)
 
+	// Args are validated against the script's declared parameters before anything runs
+	input, err := parseScriptInput(scriptName, argsList)
+	if _, invalid := err.(*inputError); invalid {
+		log.Printf("Invalid args for script: %s: %v\n", scriptName, err)
+		return []map[string]string{errorRow(scriptName, input.Raw, "invalid_args", err)}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to read parameters for script: %s: %v\n", scriptName, err)
+		return []map[string]string{errorRow(scriptName, input.Raw, "failed", err)}, nil
+	}
+
+	// The endpoint policy is checked before anything is downloaded
+	config := currentConfig()
+	if err := checkScriptPolicy(config, scriptName, input); err != nil {
+		metricPolicyDenials.inc()
+		log.Printf("Denied script: %s with args: %v: %v\n", scriptName, argsList, err)
+		return []map[string]string{errorRow(scriptName, input.Raw, "denied", err)}, nil
+	}
+
 	script, err := getScript(scriptName, cacheBool)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get script: %v", err)
 	}
 
 	var result ExecutionResult
-
-	// Use ExecTimeout from config
-	execTimeout := int(scoutConfig.ExecTimeout.Seconds())
-	if execTimeout == 0 {
-		execTimeout = 30 // Default to 30 seconds if not set
+	var queued time.Duration
+	var throttle throttleDecision
+
+	// Scripts that opt into result caching reuse a recent result for the same script and args
+	resultTTL := getResultTTL(scriptName)
+	resultFromCache := false
+	var resultKey string
+	if resultTTL > 0 {
+		resultKey = getResultKey(script.Hash, input)
+		if reuseResults {
+			result, resultFromCache = loadCachedResult(resultKey)
+		}
 	}
-	log.Printf("Executing script: %s with args: %v\n", scriptName, argsList)
 
-	result, err = executeScript(script, argsList, execTimeout)
-	if err != nil {
-		log.Printf("%+v", result)
-		return nil, fmt.Errorf("failed to execute script: %v", err)
+	if resultFromCache {
+		log.Printf("Using cached result for script: %s with args: %v\n", scriptName, argsList)
+	} else {
+		// Use ExecTimeout from config
+		execTimeout := int(config.ExecTimeout.Seconds())
+		if execTimeout == 0 {
+			execTimeout = 30 // Default to 30 seconds if not set
+		}
+
+		// Scripts wait for, or are refused or deprioritised under, a high host load
+		throttle = decideThrottle(config, scriptName)
+		if throttle.Action == throttleRejected {
+			metricThrottleRejections.inc()
+			err := fmt.Errorf("host is under load: %s", throttle.Reason)
+			log.Printf("Not running script: %s with args: %v: %v\n", scriptName, argsList, err)
+			row := errorRow(scriptName, input.Raw, "throttled", err)
+			row["throttle"] = throttle.Action
+			return []map[string]string{row}, nil
+		}
+		if throttle.Action == throttleNiced {
+			log.Printf("Running script: %s at lower priority: %s\n", scriptName, throttle.Reason)
+		}
+
+		// Wait for a slot under max_concurrent_executions and the script's own limit
+		release, waited, err := executions.acquire(config, scriptName)
+		queued = waited
+		if err != nil {
+			log.Printf("Not running script: %s with args: %v: %v\n", scriptName, argsList, err)
+			row := errorRow(scriptName, input.Raw, err.(*queueError).status, err)
+			row["queued_ms"] = strconv.FormatInt(queued.Milliseconds(), 10)
+			return []map[string]string{row}, nil
+		}
+		log.Printf("Executing script: %s with args: %v\n", scriptName, argsList)
+
+		var niceness int
+		result, niceness, err = executeScript(script, input, execTimeout, throttle.Niceness)
+		release()
+		if throttle.Action == throttleNiced && niceness == 0 {
+			// Ran at normal priority after all
+			throttle.Action = throttleNiceFailed
+		}
+		if err != nil {
+			log.Printf("%+v", result)
+			return nil, fmt.Errorf("failed to execute script: %v", err)
+		}
+
+		if resultTTL > 0 && result.Status == "completed" {
+			if err := saveCachedResult(resultKey, result, resultTTL); err != nil {
+				log.Printf("Failed to cache result: %v\n", err)
+			}
+		}
 	}
 
 	if script.Cached {
@@ -93,6 +183,12 @@ func ScoutQuickExecGenerate(ctx context.Context, queryContext table.QueryContext
 		result.FromCache = "false"
 	}
 
+	// Age of the cached copy in seconds, empty when the script was just downloaded
+	cacheAge := ""
+	if script.Cached {
+		cacheAge = strconv.Itoa(int(time.Since(script.CachedAt).Seconds()))
+	}
+
 	// Determine columns and process output
 	consoleLines := strings.Split(result.ConsoleOut, "\n")
 	if len(consoleLines) == 0 {
@@ -124,11 +220,18 @@ func ScoutQuickExecGenerate(ctx context.Context, queryContext table.QueryContext
 			}
 
 			row := map[string]string{
-				"script_name": result.ScriptName,
-				"args":        result.Args,
-				"from_cache":  useCache,
-				"status":      result.Status,
-				"columns":     strings.Join(columns, ","),
+				"script_name":       result.ScriptName,
+				"args":              result.Args,
+				"from_cache":        useCache,
+				"status":            result.Status,
+				"variant":           script.Variant,
+				"server":            script.Server,
+				"stale":             strconv.FormatBool(script.Stale),
+				"cache_age":         cacheAge,
+				"columns":           strings.Join(columns, ","),
+				"result_from_cache": strconv.FormatBool(resultFromCache),
+				"queued_ms":         strconv.FormatInt(queued.Milliseconds(), 10),
+				"throttle":          throttle.Action,
 			}
 
 			// Add JSON fields to the row
@@ -147,16 +250,23 @@ func ScoutQuickExecGenerate(ctx context.Context, queryContext table.QueryContext
 			}
 
 			rows = append(rows, map[string]string{
-				"script_name":    result.ScriptName,
-				"args":           result.Args,
-				"console_out":    line,
-				"error_out":      result.ErrorOut,
-				"execution_time": result.ExecutionTime,
-				"duration":       result.Duration,
-				"script_hash":    result.ScriptHash,
-				"from_cache":     useCache,
-				"status":         result.Status,
-				"columns":        strings.Join(columns, ","),
+				"script_name":       result.ScriptName,
+				"args":              result.Args,
+				"console_out":       line,
+				"error_out":         result.ErrorOut,
+				"execution_time":    result.ExecutionTime,
+				"duration":          result.Duration,
+				"script_hash":       result.ScriptHash,
+				"from_cache":        useCache,
+				"status":            result.Status,
+				"variant":           script.Variant,
+				"server":            script.Server,
+				"stale":             strconv.FormatBool(script.Stale),
+				"cache_age":         cacheAge,
+				"columns":           strings.Join(columns, ","),
+				"result_from_cache": strconv.FormatBool(resultFromCache),
+				"queued_ms":         strconv.FormatInt(queued.Milliseconds(), 10),
+				"throttle":          throttle.Action,
 			})
 		}
 	}
@@ -164,88 +274,92 @@ func ScoutQuickExecGenerate(ctx context.Context, queryContext table.QueryContext
 	return rows, nil
 }
 
-func ScoutScriptCacheGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
-	// Use scoutConfig.CacheDir
-	cacheDir := scoutConfig.CacheDir
-	// Create the cache directory if it does not exist
-	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
-		err = os.MkdirAll(cacheDir, 0755)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create cache directory: %v", err)
-		}
+// Helper function to build the single row reporting a run that was refused before it started
+func errorRow(scriptName string, args string, status string, err error) map[string]string {
+	return map[string]string{
+		"script_name": scriptName,
+		"args":        args,
+		"error_out":   err.Error(),
+		"status":      status,
+		"columns":     "",
 	}
+}
 
-	files, err := os.ReadDir(cacheDir)
+func ScoutScriptCacheGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	entries, err := listCachedScripts()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read cache directory: %v", err)
+		return nil, fmt.Errorf("failed to read cache database: %v", err)
 	}
 
 	var results []map[string]string
+	prefetchStates := prefetchStatusSnapshot()
 
-	for _, file := range files {
-		if file.IsDir() {
+	for _, entry := range entries {
+		if !matchesConstraints(queryContext, "name", entry.Meta.ScriptName) {
 			continue
 		}
 
-		if !strings.HasSuffix(file.Name(), ".meta") {
-			continue
+		// Recompute the SHA256 of the cached script contents and check it against the stored hash and signature
+		integrity := checkCacheEntryIntegrity(entry, currentConfig().PublicKey)
+
+		row := map[string]string{
+			"rowid":           strconv.FormatInt(entry.RowID, 10),
+			"name":            entry.Meta.ScriptName,
+			"description":     "",
+			"hash":            integrity.ComputedHash,
+			"hash_match":      strconv.FormatBool(integrity.HashMatch),
+			"signature_valid": strconv.FormatBool(integrity.SignatureValid),
+			"last_updated":    entry.Meta.CacheTime.Format(time.RFC3339),
+			"cache":           "true",
+			"path":            cacheDBPath,
+			"variant":         entry.Meta.Variant,
+			"server":          entry.Meta.Server,
+			"size":            strconv.Itoa(len(entry.StoredContents)),
+			"encrypted":       strconv.FormatBool(entry.KeyID != ""),
+			"hits":            strconv.FormatInt(entry.Meta.Hits, 10),
+			"last_accessed":   entry.Meta.LastAccessed.Format(time.RFC3339),
 		}
-
-		filePath := fmt.Sprintf("%s/%s", cacheDir, file.Name())
-		fileInfo, err := os.Stat(filePath)
-		if err != nil {
-			log.Printf("Failed to stat file: %v", err)
-			continue
+		if state, ok := prefetchStates[entry.Meta.ScriptName]; ok {
+			addPrefetchColumns(row, state)
+			delete(prefetchStates, entry.Meta.ScriptName)
 		}
+		results = append(results, row)
+	}
 
-		var script Script
-		var scriptMeta CacheMeta
-
-		// Process meta file
-		metaData, err := os.ReadFile(filePath)
-		if err != nil {
-			log.Printf("Failed to read meta file: %v", err)
+	// Scripts in the prefetch list that haven't made it into the cache yet
+	// These get negative rowids so a DELETE can never match a cached entry
+	uncachedRowID := int64(-1)
+	for name, state := range prefetchStates {
+		if !matchesConstraints(queryContext, "name", name) {
 			continue
 		}
-		err = json.Unmarshal(metaData, &scriptMeta)
-		if err != nil {
-			log.Printf("Failed to unmarshal meta file: %v", err)
-			continue
+		row := map[string]string{
+			"rowid": strconv.FormatInt(uncachedRowID, 10),
+			"name":  name,
+			"cache": "false",
 		}
-		script.Name = scriptMeta.ScriptName
-		script.Hash = scriptMeta.ScriptHash
-		script.Description = ""
-
-		scriptFilePath := strings.TrimSuffix(filePath, ".meta")
-		// Calculate the SHA256 of script file contents
-		scriptData, err := os.ReadFile(scriptFilePath)
-		if err != nil {
-			log.Printf("Failed to read script file: %v", err)
-			continue
-		}
-		hasher := sha256.New()
-		hasher.Write(scriptData)
-		hashed := hasher.Sum(nil)
-		script.Hash = hex.EncodeToString(hashed)
-
-		results = append(results, map[string]string{
-			"name":         script.Name,
-			"description":  script.Description,
-			"hash":         script.Hash,
-			"last_updated": fileInfo.ModTime().Format(time.RFC3339),
-			"cache":        "true",
-			"path":         filePath,
-		})
+		uncachedRowID--
+		addPrefetchColumns(row, state)
+		results = append(results, row)
 	}
 
 	return results, nil
 }
 
-func executeScript(script Script, argsList []string, exec_timeout int) (result ExecutionResult, err error) {
+func addPrefetchColumns(row map[string]string, state prefetchStatus) {
+	row["prefetch"] = "true"
+	row["prefetch_status"] = state.Status
+	row["prefetch_error"] = state.Error
+	if !state.LastAttempt.IsZero() {
+		row["prefetch_time"] = state.LastAttempt.Format(time.RFC3339)
+	}
+}
+
+func executeScript(script Script, input scriptInput, exec_timeout int, niceness int) (result ExecutionResult, appliedNiceness int, err error) {
 	execResult := ExecutionResult{
 		JobID:      "quick_exec",
 		ScriptName: script.Name,
-		Args:       strings.Join(argsList, " "),
+		Args:       input.Raw,
 		Status:     "running",
 		ScriptHash: script.Hash,
 	}
@@ -274,7 +388,7 @@ func executeScript(script Script, argsList []string, exec_timeout int) (result E
 	if err != nil {
 		execResult.ErrorOut = fmt.Sprintf("Failed to create temp file: %v", err)
 		execResult.Status = "failed"
-		return execResult, err
+		return execResult, 0, err
 	}
 	defer os.Remove(tmpFile.Name())
 
@@ -283,7 +397,7 @@ func executeScript(script Script, argsList []string, exec_timeout int) (result E
 	if err != nil {
 		execResult.ErrorOut = fmt.Sprintf("Failed to write script to temp file: %v", err)
 		execResult.Status = "failed"
-		return execResult, err
+		return execResult, 0, err
 	}
 	tmpFile.Close()
 
@@ -293,7 +407,7 @@ func executeScript(script Script, argsList []string, exec_timeout int) (result E
 		if err != nil {
 			execResult.ErrorOut = fmt.Sprintf("Failed to set script executable: %v", err)
 			execResult.Status = "failed"
-			return execResult, err
+			return execResult, 0, err
 		}
 	}
 
@@ -304,56 +418,40 @@ func executeScript(script Script, argsList []string, exec_timeout int) (result E
 	var cmd *exec.Cmd
 	var cmdArgs []string
 
-	var scriptArgs string
-	if len(argsList) > 0 {
-		scriptArgs = strings.Join(argsList, " ")
-	}
-
-	// Split scriptArgs into a slice, considering quotes
-	argsSlice := parseArguments(scriptArgs)
+	// Every platform gets the same arguments, as parsed by parseScriptInput
+	argsSlice := input.Args
 
 	switch runtime.GOOS {
 	case "windows":
 		if isPowerShellScript(script.Name) {
-			//execute the tmp file with args as a powershell script using hte powershell.go module
-			ps := New()
-			stdOut, stdErr, err := ps.execute(tmpFile.Name(), argsList...)
-			if err != nil {
-				execResult.ErrorOut = stdErr
-				execResult.Status = "failed"
-				return execResult, err
-			}
-			execResult.ConsoleOut = stdOut
-			execResult.ErrorOut = stdErr
-			execResult.Status = "completed"
-			return execResult, err
-
+			cmd = New().command(ctx, tmpFile.Name(), argsSlice...)
 		} else if isBatchScript(script.Name) {
-			cmdArgs = append([]string{"/C", tmpFile.Name()}, argsList...)
+			cmdArgs = append([]string{"/C", tmpFile.Name()}, argsSlice...)
 			cmd = exec.CommandContext(ctx, "cmd.exe", cmdArgs...)
 		} else if isVBScript(script.Name) {
-			cmdArgs = append([]string{tmpFile.Name()}, argsList...)
+			cmdArgs = append([]string{tmpFile.Name()}, argsSlice...)
 			cmd = exec.CommandContext(ctx, "cscript.exe", cmdArgs...)
 		} else if isPythonScript(script.Name) {
-			cmdArgs = append([]string{tmpFile.Name()}, argsList...)
+			cmdArgs = append([]string{tmpFile.Name()}, argsSlice...)
 			ep, err := python.NewEmbeddedPython("example")
 			if err != nil {
-				panic(err)
-			}
-			cmd, err := ep.PythonCmd(cmdArgs...)
-			if err != nil {
-				panic(err)
+				execResult.ErrorOut = fmt.Sprintf("Failed to prepare embedded python: %v", err)
+				execResult.Status = "failed"
+				return execResult, 0, err
 			}
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			err = cmd.Run()
+			pyCmd, err := ep.PythonCmd(cmdArgs...)
 			if err != nil {
-				panic(err)
+				execResult.ErrorOut = fmt.Sprintf("Failed to prepare embedded python: %v", err)
+				execResult.Status = "failed"
+				return execResult, 0, err
 			}
+			// Rebuilt under ctx so the timeout applies like for every other script
+			cmd = exec.CommandContext(ctx, pyCmd.Path, pyCmd.Args[1:]...)
+			cmd.Env = pyCmd.Env
 		} else {
 			execResult.ErrorOut = "Unsupported script type on Windows"
 			execResult.Status = "failed"
-			return execResult, err
+			return execResult, 0, err
 		}
 	case "darwin", "linux":
 		if isShellScript(script.Name) {
@@ -365,212 +463,198 @@ func executeScript(script Script, argsList []string, exec_timeout int) (result E
 		} else {
 			execResult.ErrorOut = "Unsupported script type on Unix"
 			execResult.Status = "failed"
-			return execResult, err
+			return execResult, 0, err
 		}
 	default:
 		execResult.ErrorOut = "Unsupported OS"
 		execResult.Status = "failed"
-		return execResult, err
+		return execResult, 0, err
 	}
 
-	// If not PowerShell inline execution, proceed with the usual command execution flow
-	if cmd != nil {
-		execResult.JobID = "quick_exec"
-		execResult, err = startCommandExecution(cmd, execResult, ctx)
+	// Scripts run while the host is under load start with a lower priority
+	if niceness > 0 {
+		if niced, err := lowPriorityCommand(ctx, cmd, niceness); err != nil {
+			log.Printf("Failed to lower script priority, running it at normal priority: %v\n", err)
+		} else {
+			cmd = niced
+			appliedNiceness = niceness
+		}
 	}
 
-	return execResult, err
+	// Named parameters go in the environment or on stdin
+	if err := input.deliver(cmd); err != nil {
+		execResult.ErrorOut = fmt.Sprintf("Failed to pass script parameters: %v", err)
+		execResult.Status = "failed"
+		return execResult, 0, err
+	}
+
+	execResult, err = startCommandExecution(cmd, execResult, ctx)
+
+	return execResult, appliedNiceness, err
 }
 
+// Concurrent loads of the same script share one download and verification
+var scriptLoads singleflight.Group
+
+// getScript returns a verified script, from the cache or the script servers. Callers asking
+// for the same script at the same time wait for a single load and share its result.
 func getScript(scriptName string, useCache bool) (Script, error) {
-	// Use scoutConfig variables directly
-	serverURL := scoutConfig.ServerURL
-	publicKeyStr := scoutConfig.PublicKey
-	cacheWindow := scoutConfig.CacheWindow
-	cacheDir := scoutConfig.CacheDir
-
-	// Compute the cache key (script hash)
-	var scriptData []byte
-	var scriptHash string
-	var fromCache bool
-	var cacheErr error
-	var cacheTimestamp time.Time
-	var cacheEnabled = true
+	metricScriptRequests.inc()
+
+	// Loads that bypass the cache mustn't be answered by one that revalidated it
+	loadKey := getCacheKey(getScriptURL(currentConfig().ServerURL, scriptName)) + ":" + strconv.FormatBool(useCache)
+	loaded := false
+	result, err, _ := scriptLoads.Do(loadKey, func() (interface{}, error) {
+		loaded = true
+		metricScriptFetches.inc()
+		return loadScript(scriptName, useCache)
+	})
+	if !loaded {
+		metricCoalescedRequests.inc()
+	}
+	return result.(Script), err
+}
 
-	cacheValid := false
+func loadScript(scriptName string, useCache bool) (Script, error) {
+	// One snapshot of the config for the whole load, the primary server URL keys the cache
+	config := currentConfig()
+	serverURL := config.ServerURL
+	publicKeyStr := config.PublicKey
+	cacheWindow := config.CacheWindow
+	var cacheEnabled = true
 
 	// Construct the full URL with URL-encoded script name
-	//fullURL := fmt.Sprintf("%s/%s", strings.TrimRight(serverURL, "/"), url.PathEscape(scriptName))
 	fullURL := getScriptURL(serverURL, scriptName)
 	cacheKey := getCacheKey(fullURL)
-	script := Script{}
-	if useCache {
-		// Attempt to load script from cache
-		//check the file exists
-		cacheMutex.Lock()
-		var scriptMeta CacheMeta
-		scriptData, scriptMeta, cacheErr = loadScriptFromCache(cacheKey, cacheDir)
-		scriptHash = scriptMeta.ScriptHash
-		cacheTimestamp = scriptMeta.CacheTime
-		scriptName = scriptMeta.ScriptName
-		if cacheErr == nil {
-			log.Printf("Script loaded from cache: %s\n", scriptName)
-		} else {
+
+	// A cached copy is only used after its signature checks out locally: with from_cache to
+	// revalidate it against the server with a conditional GET, and under the offline policy
+	// as a fallback when no script server can be reached
+	cachedScript, cachedMeta, cacheErr := loadVerifiedScriptFromCache(cacheKey, publicKeyStr)
+	if cacheErr != nil {
+		var integrityErr *cacheIntegrityError
+		if errors.As(cacheErr, &integrityErr) {
+			// Quarantined as evidence unless it's only under a key that is no longer configured
+			log.Printf("Failed to load script from cache: %v\n", cacheErr)
+			handleFailedCacheEntry(integrityErr.entry, integrityErr.result)
+		} else if cacheErr != errCacheMiss {
 			log.Printf("Failed to load script from cache: %v\n", cacheErr)
-			cacheValid = false
 		}
-		cacheMutex.Unlock()
-		cacheValid := false
-		if cacheErr == nil {
-			// Check if cache has expired
-			if time.Since(cacheTimestamp) < cacheWindow {
-				// Check with server if the script hash is still current
-				currentHash, err := getScriptHashFromServer(serverURL, scriptName)
-				if err == nil && currentHash == scriptHash {
-					// check that the signature file exists, the signature is valid, and the script hash matches
-					signatureFilePath := getSignatureFilePath(cacheKey, cacheDir)
-					if _, err := os.Stat(signatureFilePath); err == nil {
-						signature, err := loadSignatureFromCache(cacheKey, cacheDir)
-						if err == nil {
-							// Parse the public key
-							block, _ := pem.Decode([]byte(publicKeyStr))
-							if block != nil {
-								pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-								if err == nil {
-									rsaPub, ok := pub.(*rsa.PublicKey)
-									if ok {
-										// Compute script hash
-										hasher := sha256.New()
-										hasher.Write(scriptData)
-										hashed := hasher.Sum(nil)
-										scriptHash = hex.EncodeToString(hashed)
-
-										// Verify the script signature
-										err = rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, hashed, signature)
-										if err == nil {
-											cacheValid = true
-											fromCache = true
-											script = Script{
-												Name:     scriptName,
-												Contents: scriptData,
-												Hash:     scriptHash,
-												Cached:   fromCache,
-											}
-											return script, nil
-										} else {
-											log.Printf("Script signature verification failed: %v\n", err)
-										}
-									} else {
-										log.Printf("Public key is not RSA\n")
-									}
-								} else {
-									log.Printf("Failed to parse public key: %v\n", err)
-								}
-							} else {
-								log.Printf("Failed to parse public key PEM\n")
-							}
-						} else {
-							log.Printf("Failed to load signature from cache: %v\n", err)
-						}
-					} else {
-						log.Printf("Signature file not found in cache: %v\n", err)
-					}
-				} else if err == nil {
-					log.Printf("Script hash mismatch: %s != %s\n", currentHash, scriptHash)
-				} else {
-					log.Printf("Error fetching script hash from server: %v\n", err)
-				}
-			} else {
-				log.Printf("Cache expired for script: %s\n", scriptName)
-			}
+		cachedScript = nil
+	}
+
+	var validators *CacheMeta
+	if useCache && cachedScript != nil {
+		if time.Since(cachedMeta.CacheTime) >= cacheWindow {
+			log.Printf("Cache expired for script: %s\n", scriptName)
+		} else if cachedMeta.ETag == "" && cachedMeta.LastModified == "" {
+			log.Printf("Cached script has no ETag or Last-Modified to revalidate: %s\n", scriptName)
+		} else {
+			log.Printf("Script loaded from cache: %s\n", cachedMeta.ScriptName)
+			validators = &cachedMeta
 		}
+	}
 
-		if !cacheValid || !useCache || cacheErr != nil {
-			fromCache = false
-			// Remove invalid cache entry
-			cacheMutex.Lock()
-			removeScriptFromCache(cacheKey, cacheDir)
-			cacheMutex.Unlock()
+	// Fetch the script from the first healthy server that has it
+	respHTTP, scriptServer, scriptVariant, err := fetchScriptResponse(scriptName, validators)
+	if err != nil {
+		if _, unreachable := err.(*serverError); unreachable && cachedScript != nil && allowOffline(cachedMeta) {
+			log.Printf("Script server unreachable (%v), running cached script under offline policy %q: %s\n", err, config.OfflinePolicy, scriptName)
+			cachedScript.Stale = true
+			recordCacheHit(cacheKey)
+			return *cachedScript, nil
 		}
+		return Script{}, err
 	}
+	defer respHTTP.Body.Close()
 
-	if !cacheValid {
-		// Fetch the script
-		log.Printf("Fetching script from server at url: %s\n", fullURL)
-		respHTTP, err := http.Get(fullURL)
+	if respHTTP.StatusCode == http.StatusNotModified {
+		log.Printf("Cached script is current on server %s: %s\n", scriptServer, scriptName)
+		// Restart the cache window now that the server has confirmed the copy
+		cachedMeta.CacheTime = time.Now()
+		err = touchCacheEntry(cacheKey, cachedMeta.CacheTime)
 		if err != nil {
-			return Script{}, fmt.Errorf("failed to fetch script: %v", err)
+			log.Printf("Failed to update cache metadata: %v\n", err)
 		}
-		defer respHTTP.Body.Close()
+		cachedScript.CachedAt = cachedMeta.CacheTime
+		recordCacheHit(cacheKey)
+		return *cachedScript, nil
+	}
 
-		if respHTTP.StatusCode != http.StatusOK {
-			return Script{}, fmt.Errorf("failed to fetch script: received status code %d", respHTTP.StatusCode)
-		}
+	scriptData, err := readResponseBody(respHTTP)
+	if err != nil {
+		return Script{}, fmt.Errorf("failed to read script data: %v", err)
+	}
 
-		scriptData, err = io.ReadAll(respHTTP.Body)
-		if err != nil {
-			return Script{}, fmt.Errorf("failed to read script data: %v", err)
-		}
+	// Get the signature from the response header
+	signatureHex := respHTTP.Header.Get("X-Signature")
+	if signatureHex == "" {
+		return Script{}, fmt.Errorf("no signature in response header")
+	}
 
-		// Get the signature from the response header
-		signatureHex := respHTTP.Header.Get("X-Signature")
-		if signatureHex == "" {
-			return Script{}, fmt.Errorf("no signature in response header")
-		}
+	// Decode the signature from hex
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return Script{}, fmt.Errorf("failed to decode signature: %v", err)
+	}
 
-		// Decode the signature from hex
-		signature, err := hex.DecodeString(signatureHex)
-		if err != nil {
-			return Script{}, fmt.Errorf("failed to decode signature: %v", err)
-		}
+	scriptHash, err := verifyScriptSignature(scriptData, signature, publicKeyStr)
+	if err != nil {
+		return Script{}, err
+	}
 
-		// Parse the public key
-		block, _ := pem.Decode([]byte(publicKeyStr))
-		if block == nil {
-			return Script{}, fmt.Errorf("failed to parse public key PEM")
-		}
-		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	// Construct the script object
+	script := Script{
+		Name:     scriptName,
+		Contents: scriptData,
+		Hash:     scriptHash,
+		Cached:   false,
+		Variant:  scriptVariant,
+		Server:   scriptServer,
+		CachedAt: time.Now(),
+	}
+
+	// Save script to cache - Currently cache is enabled by default, need to wipe cache after a certain time
+	if cacheEnabled {
+		err = saveScriptToCache(cacheKey, script, signature, keyFingerprint(publicKeyStr), respHTTP.Header.Get("ETag"), respHTTP.Header.Get("Last-Modified"))
 		if err != nil {
-			return Script{}, fmt.Errorf("failed to parse public key: %v", err)
-		}
-		rsaPub, ok := pub.(*rsa.PublicKey)
-		if !ok {
-			return Script{}, fmt.Errorf("public key is not rsa")
+			return Script{}, fmt.Errorf("failed to save script to cache: %v", err)
 		}
+	}
+	log.Printf("Script fetched and verified from server %s: %s (variant: %s)\n", scriptServer, scriptName, scriptVariant)
 
-		// Compute script hash
-		hasher := sha256.New()
-		hasher.Write(scriptData)
-		hashed := hasher.Sum(nil)
-		scriptHash = hex.EncodeToString(hashed)
+	return script, nil
+}
 
-		// Verify the script signature
-		err = rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, hashed, signature)
-		if err != nil {
-			return Script{}, fmt.Errorf("Script signature verification failed: %v", err)
-		}
+// verifyScriptSignature checks an RSA PKCS#1 v1.5 signature over the SHA-256 of the script
+// against the configured public key, returning the hex encoded hash
+func verifyScriptSignature(scriptData []byte, signature []byte, publicKeyStr string) (string, error) {
+	// Parse the public key
+	block, _ := pem.Decode([]byte(publicKeyStr))
+	if block == nil {
+		return "", fmt.Errorf("failed to parse public key PEM")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse public key: %v", err)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("public key is not rsa")
+	}
 
-		// Construct the script object
-		script = Script{
-			Name:     scriptName,
-			Contents: scriptData,
-			Hash:     scriptHash,
-			Cached:   fromCache,
-		}
+	// Compute script hash
+	hasher := sha256.New()
+	hasher.Write(scriptData)
+	hashed := hasher.Sum(nil)
 
-		// Save script to cache - Currently cache is enabled by default, need to wipe cache after a certain time
-		if cacheEnabled {
-			cacheMutex.Lock()
-			err = saveScriptToCache(cacheKey, script, signature, cacheDir)
-			cacheMutex.Unlock()
-			if err != nil {
-				return Script{}, fmt.Errorf("failed to save script to cache: %v", err)
-			}
-		}
-		log.Printf("Script fetched and verified from server: %s\n", scriptName)
+	// Verify the script signature
+	err = rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, hashed, signature)
+	if err != nil {
+		return "", fmt.Errorf("Script signature verification failed: %v", err)
 	}
 
-	return script, nil
+	return hex.EncodeToString(hashed), nil
 }
 
 // Helper function to handle actual command execution and capture stdout and stderr
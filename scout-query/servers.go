@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverHealth tracks failures of a single script server for the circuit breaker
+type serverHealth struct {
+	URL         string
+	Failures    int       // Consecutive failures
+	OpenUntil   time.Time // Server is skipped until this time once the breaker trips
+	LastError   string
+	LastSuccess time.Time
+}
+
+// serverPool is the ordered list of script servers, highest priority first
+type serverPool struct {
+	mu       sync.Mutex
+	servers  []*serverHealth
+	maxFails int
+	cooldown time.Duration
+}
+
+// Health of the configured script servers, replaced when a config reload changes the servers
+var scriptServers atomic.Pointer[serverPool]
+
+func newServerPool(config ScoutConfig) *serverPool {
+	pool := &serverPool{
+		maxFails: config.BreakerThreshold,
+		cooldown: config.BreakerCooldown,
+	}
+	if pool.maxFails <= 0 {
+		pool.maxFails = 3
+	}
+	for _, serverURL := range config.ServerURLs {
+		pool.servers = append(pool.servers, &serverHealth{URL: serverURL})
+	}
+	return pool
+}
+
+// available returns the servers whose circuit breaker is closed, in priority order
+func (p *serverPool) available() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var urls []string
+	for _, server := range p.servers {
+		if now.Before(server.OpenUntil) {
+			continue
+		}
+		urls = append(urls, server.URL)
+	}
+	return urls
+}
+
+func (p *serverPool) recordSuccess(serverURL string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, server := range p.servers {
+		if server.URL == serverURL {
+			server.Failures = 0
+			server.OpenUntil = time.Time{}
+			server.LastSuccess = time.Now()
+		}
+	}
+}
+
+func (p *serverPool) recordFailure(serverURL string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, server := range p.servers {
+		if server.URL != serverURL {
+			continue
+		}
+		server.Failures++
+		server.LastError = err.Error()
+		if server.Failures >= p.maxFails {
+			server.OpenUntil = time.Now().Add(p.cooldown)
+			log.Printf("Script server %s marked unhealthy after %d failures, skipping for %s\n", serverURL, server.Failures, p.cooldown)
+		}
+	}
+}
+
+// serverError marks a failure that should make us fail over to the next server:
+// a network error or a 5xx response once retries are exhausted
+type serverError struct {
+	err error
+}
+
+func (e *serverError) Error() string {
+	return e.err.Error()
+}
+
+// fetchScriptResponse walks the servers in priority order and, on each server, the variant
+// fallback chain until one returns the script. Network errors and 5xx responses count against
+// the server's health and fail over to the next one; a 404 on every variant moves on without
+// penalising the server.
+//
+// When cached is set, its variant is tried first as a conditional GET using the stored ETag and
+// Last-Modified, and the response may be a 304 Not Modified. The caller must close the response body.
+func fetchScriptResponse(scriptName string, cached *CacheMeta) (resp *http.Response, serverURL string, variant string, err error) {
+	pool := scriptServers.Load()
+	servers := pool.available()
+	if len(servers) == 0 {
+		return nil, "", "", &serverError{fmt.Errorf("all script servers are unhealthy")}
+	}
+
+	var lastErr error
+	for _, serverURL := range servers {
+		resp, variant, err := fetchScriptVariant(serverURL, scriptName, cached)
+		if err == nil {
+			pool.recordSuccess(serverURL)
+			return resp, serverURL, variant, nil
+		}
+		if _, ok := err.(*serverError); ok {
+			pool.recordFailure(serverURL, err)
+			log.Printf("Script server %s failed: %v, trying next server\n", serverURL, err)
+		}
+		lastErr = err
+	}
+	return nil, "", "", lastErr
+}
+
+func fetchScriptVariant(serverURL, scriptName string, cached *CacheMeta) (*http.Response, string, error) {
+	variants := getScriptVariants()
+
+	// The chain is walked most specific first even when a copy is cached, so a more specific
+	// variant published since is picked up. The cached copy is only revalidated when the walk
+	// reaches its variant (or the first variant, for entries cached without one).
+	for i, variant := range variants {
+		revalidate := cached != nil && (variant == cached.Variant || (cached.Variant == "" && i == 0))
+		variantURL := getVariantURL(serverURL, variant, scriptName)
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, variantURL, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		if revalidate {
+			if cached.ETag != "" {
+				req.Header.Set("If-None-Match", cached.ETag)
+			}
+			if cached.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cached.LastModified)
+			}
+		}
+
+		log.Printf("Fetching script from server at url: %s\n", variantURL)
+		resp, err := doRequest(req)
+		if err != nil {
+			return nil, "", &serverError{fmt.Errorf("failed to fetch script: %v", err)}
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			continue
+		}
+		if resp.StatusCode == http.StatusNotModified && revalidate {
+			return resp, variant, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = fmt.Errorf("failed to fetch script: received status code %d", resp.StatusCode)
+			if resp.StatusCode >= 500 {
+				return nil, "", &serverError{err}
+			}
+			return nil, "", err
+		}
+		return resp, variant, nil
+	}
+	return nil, "", fmt.Errorf("script %s not found on server %s (tried variants: %s)", scriptName, serverURL, strings.Join(variants, ", "))
+}
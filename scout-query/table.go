@@ -26,6 +26,14 @@ func QuickExecColumns() []table.ColumnDefinition {
 		table.TextColumn("duration"),
 		table.TextColumn("script_hash"),
 		table.TextColumn("from_cache"),
+		table.TextColumn("status"),
+		table.TextColumn("variant"),
+		table.TextColumn("server"),
+		table.TextColumn("stale"),
+		table.IntegerColumn("cache_age"),
+		table.TextColumn("result_from_cache"),
+		table.IntegerColumn("queued_ms"),
+		table.TextColumn("throttle"),
 		table.TextColumn("columns"),
 	}
 }
@@ -36,9 +44,31 @@ func CachedScriptsColumns() []table.ColumnDefinition {
 		table.TextColumn("name"),
 		table.TextColumn("description"),
 		table.TextColumn("hash"),
+		table.TextColumn("hash_match"),
+		table.TextColumn("signature_valid"),
 		table.TextColumn("last_updated"),
 		table.TextColumn("cache"),
 		table.TextColumn("path"),
+		table.TextColumn("variant"),
+		table.TextColumn("server"),
+		table.BigIntColumn("size"),
+		table.TextColumn("encrypted"),
+		table.BigIntColumn("hits"),
+		table.TextColumn("last_accessed"),
+		table.TextColumn("prefetch"),
+		table.TextColumn("prefetch_status"),
+		table.TextColumn("prefetch_error"),
+		table.TextColumn("prefetch_time"),
+	}
+}
+
+// Columns for the table that runs cache maintenance actions
+func CacheOpsColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("action"),
+		table.TextColumn("name"),
+		table.TextColumn("status"),
+		table.TextColumn("message"),
 	}
 }
 
@@ -73,3 +103,20 @@ func ExecResultsColumns() []table.ColumnDefinition {
 		table.TextColumn("timestamp"),
 	}
 }
+
+// Columns for the effective configuration table
+func ConfigColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("key"),
+		table.TextColumn("value"),
+	}
+}
+
+// Columns for the extension metrics table
+func MetricsColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("name"),
+		table.BigIntColumn("value"),
+		table.TextColumn("description"),
+	}
+}
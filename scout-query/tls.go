@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Map of min_tls_version config values to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the TLS settings for the script server connection: custom CA trust,
+// client certificates for mutual TLS, SNI override, minimum version and SPKI pinning
+func newTLSConfig(config ScoutConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.ServerName,
+	}
+
+	if config.MinTLSVersion != "" {
+		version, ok := tlsVersions[config.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported min_tls_version %q", config.MinTLSVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if config.CABundle != "" {
+		pemData, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificates found in ca_bundle %s", config.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCert != "" || config.ClientKey != "" {
+		if config.ClientCert == "" || config.ClientKey == "" {
+			return nil, fmt.Errorf("client_cert and client_key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.SPKIPins) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range config.SPKIPins {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+			if err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("invalid spki_pins entry %q: expected base64 encoded sha256", pin)
+			}
+			pins[string(decoded)] = true
+		}
+
+		// This transport only dials the script servers, so pins are enforced on every connection
+		// rather than looked up by SNI: Go sends no SNI for IP addresses, and an empty name
+		// must not skip the check. The one exception is the TLS connection to an https proxy.
+		pinnedHosts := make(map[string]bool)
+		if config.ServerName != "" {
+			pinnedHosts[strings.ToLower(config.ServerName)] = true
+		}
+		for _, serverURL := range config.ServerURLs {
+			if u, err := url.Parse(serverURL); err == nil {
+				pinnedHosts[strings.ToLower(u.Hostname())] = true
+			}
+		}
+		proxyHost := ""
+		if u, err := url.Parse(config.ProxyURL); err == nil && strings.EqualFold(u.Scheme, "https") {
+			proxyHost = strings.ToLower(u.Hostname())
+		}
+
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			name := strings.ToLower(cs.ServerName)
+			if proxyHost != "" && name == proxyHost && !pinnedHosts[name] {
+				return nil
+			}
+			for _, cert := range cs.PeerCertificates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[string(sum[:])] {
+					return nil
+				}
+			}
+			return fmt.Errorf("script server certificate does not match any pinned public key")
+		}
+	}
+
+	return tlsConfig, nil
+}
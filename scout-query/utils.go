@@ -6,28 +6,138 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/osquery/osquery-go"
 	"github.com/osquery/osquery-go/plugin/table"
 )
 
 type ScoutConfig struct {
-	ServerURL   string        `json:"server_url"`
+	SourcePath string `json:"-"` // Config file the settings were read from
+
+	ServerURL   string        `json:"server_url"` // Primary script server, always ServerURLs[0]
+	ServerURLs  []string      `json:"server_urls"`
 	PublicKey   string        `json:"public_key"`
 	CacheWindow time.Duration `json:"cache_window"`
 	ExecTimeout time.Duration `json:"exec_timeout"`
 	CacheDir    string        `json:"cache_dir"`
+
+	// HTTP client settings for requests to the script server
+	ConnectTimeout   time.Duration `json:"connect_timeout"`
+	ReadTimeout      time.Duration `json:"read_timeout"`
+	MaxDownloadBytes int64         `json:"max_download_bytes"`
+	MaxRetries       int           `json:"max_retries"`
+	RetryBackoff     time.Duration `json:"retry_backoff"`
+	RetryMaxBackoff  time.Duration `json:"retry_max_backoff"`
+
+	// TLS settings for the script server connection
+	CABundle      string   `json:"ca_bundle"`
+	ClientCert    string   `json:"client_cert"`
+	ClientKey     string   `json:"client_key"`
+	ServerName    string   `json:"server_name"`
+	MinTLSVersion string   `json:"min_tls_version"`
+	SPKIPins      []string `json:"spki_pins"`
+
+	// Proxy and authentication for the script server
+	ProxyURL        string   `json:"proxy_url"`
+	NoProxy         []string `json:"no_proxy"`
+	BearerToken     string   `json:"bearer_token"`
+	BearerTokenFile string   `json:"bearer_token_file"`
+	UseEnrollSecret bool     `json:"use_enroll_secret"`
+	APIKey          string   `json:"api_key"`
+	APIKeyHeader    string   `json:"api_key_header"`
+
+	// Circuit breaker for failing over between script servers
+	BreakerThreshold int           `json:"breaker_threshold"`
+	BreakerCooldown  time.Duration `json:"breaker_cooldown"`
+
+	// Whether verified cached scripts may run when no script server is reachable
+	OfflinePolicy   string        `json:"offline_policy"`
+	OfflineMaxStale time.Duration `json:"offline_max_stale"`
+
+	// Cache size limits, enforced by the cache janitor in least recently used order
+	MaxCacheBytes        int64         `json:"max_cache_bytes"`
+	MaxCacheEntries      int           `json:"max_cache_entries"`
+	CacheJanitorInterval time.Duration `json:"cache_janitor_interval"`
+
+	// Scripts (names or manifest globs) to download ahead of time and keep refreshed
+	Prefetch         []string      `json:"prefetch"`
+	PrefetchInterval time.Duration `json:"prefetch_interval"`
+
+	// How often to scan the cache for tampered entries, 0 disables the scan
+	IntegrityScanInterval time.Duration `json:"integrity_scan_interval"`
+
+	// Allow DELETE on scout_cache and maintenance actions through scout_cache_ops
+	AllowCacheOps bool `json:"allow_cache_ops"`
+
+	// How often to check the config for changes, 0 disables live reload
+	ConfigReloadInterval time.Duration `json:"config_reload_interval"`
+
+	// How often config reloads ask osquery's config plugin again, 0 only at startup
+	OsqueryConfigRefresh time.Duration `json:"osquery_config_refresh"`
+
+	// Result cache TTLs by script name or glob, see getResultTTL
+	ResultCacheTTLs map[string]time.Duration `json:"result_cache"`
+
+	// Encrypt cached script contents with AES-GCM, see initCacheEncryption
+	EncryptCache   bool   `json:"encrypt_cache"`
+	CacheKeySource string `json:"cache_key_source"`
+	CacheKeyFile   string `json:"cache_key_file"`
+
+	// Endpoint policy for scout_exec, see checkScriptPolicy
+	AllowScripts   []string                `json:"allow_scripts"`
+	DenyScripts    []string                `json:"deny_scripts"`
+	ScriptPolicies map[string]ScriptPolicy `json:"script_policy"`
+
+	// How many runs of a scout_exec query that fans out over several scripts or args run at once
+	FanOutWorkers int `json:"fan_out_workers"`
+
+	// Limits on scripts running at once, see execLimiter. 0 is unlimited.
+	MaxConcurrentExecutions int           `json:"max_concurrent_executions"`
+	MaxConcurrentPerScript  int           `json:"max_concurrent_per_script"`
+	ExecutionQueueSize      int           `json:"execution_queue_size"`
+	ExecutionQueueTimeout   time.Duration `json:"execution_queue_timeout"`
+
+	// Host load thresholds checked before a script runs, see decideThrottle. 0 disables a threshold.
+	ThrottleMaxLoad         float64       `json:"throttle_max_load"`
+	ThrottleMinFreeMemoryMB float64       `json:"throttle_min_free_memory_mb"`
+	ThrottleMaxCPUPercent   float64       `json:"throttle_max_cpu_percent"`
+	ThrottleAction          string        `json:"throttle_action"`
+	ThrottleDeferMax        time.Duration `json:"throttle_defer_max"`
+	ThrottleNice            int           `json:"throttle_nice"`
 }
 
 var (
-	cacheMutex   sync.Mutex
 	cacheDirName = "scout_cache"
-	scoutConfig  ScoutConfig // Package-level variable to hold the config
+	activeConfig atomic.Pointer[ScoutConfig] // Config in effect, see currentConfig
+	loadedAt     atomic.Int64                // When the config in effect was applied, unix nanoseconds
 )
 
+// currentConfig returns the config in effect. A reload replaces it as a whole rather than
+// modifying it, so callers reading several settings should take one snapshot and use that.
+func currentConfig() *ScoutConfig {
+	if config := activeConfig.Load(); config != nil {
+		return config
+	}
+	return &ScoutConfig{}
+}
+
+// setConfig makes config the one in effect
+func setConfig(config ScoutConfig) {
+	activeConfig.Store(&config)
+	loadedAt.Store(time.Now().UnixNano())
+}
+
+// configLoadedAt returns when the config in effect was applied
+func configLoadedAt() time.Time {
+	return time.Unix(0, loadedAt.Load())
+}
+
 func fetchScoutConfig(scoutConfFlag string) (config ScoutConfig, err error) {
 	config = ScoutConfig{}
 
@@ -59,9 +169,21 @@ func fetchScoutConfig(scoutConfFlag string) (config ScoutConfig, err error) {
 		} else {
 			configPath = resp.Response[0]["value"]
 		}
+
+		// Prefer the config osquery itself loaded, falling back to reading the file directly
+		scoutOptions, source, err := loadOsqueryScoutOptions(client)
+		if err == nil {
+			config, err = parseScoutConfig(scoutOptions, configPath)
+			config.SourcePath = source
+			return config, err
+		}
+		if err != errNoScoutSection {
+			log.Printf("Failed to read scout settings from osquery, reading %s instead: %v\n", configPath, err)
+		}
 	}
 
 	// Read the config file
+	sourcePath := configPath
 	configData, err := os.ReadFile(configPath)
 	if err != nil {
 		// Try reading scout.conf in the same directory if the initial configPath fails
@@ -70,6 +192,7 @@ func fetchScoutConfig(scoutConfFlag string) (config ScoutConfig, err error) {
 		if err != nil {
 			return config, fmt.Errorf("failed to read config file: %v", err)
 		}
+		sourcePath = scoutConfigPath
 	}
 
 	// Parse the config JSON
@@ -97,41 +220,73 @@ func fetchScoutConfig(scoutConfFlag string) (config ScoutConfig, err error) {
 
 		scoutOptions, ok = configMap["scout"].(map[string]interface{})
 		if !ok {
-			return config, fmt.Errorf("no 'scout' section in config")
+			return config, errNoScoutSection
 		}
+		sourcePath = scoutConfigPath
 	}
 
-	// Get the server URL, public key, and cache window from the config
-	config.ServerURL, ok = scoutOptions["script_server_url"].(string)
-	if !ok {
-		return config, fmt.Errorf("no 'script_server_url' in 'scout' section")
+	config, err = parseScoutConfig(scoutOptions, configPath)
+	if err != nil {
+		return config, err
 	}
+	config.SourcePath = sourcePath
 
-	config.PublicKey, ok = scoutOptions["public_key"].(string)
-	if !ok {
-		return config, fmt.Errorf("no 'public_key' in 'scout' section")
-	}
+	return config, nil
+}
 
-	config.CacheWindow = 3600 * time.Second
-	if val, ok := scoutOptions["cache_window_seconds"].(float64); ok {
-		config.CacheWindow = time.Duration(val) * time.Second
+// Read a config value that may be a single string or a list of strings
+func getStringList(value interface{}) []string {
+	var list []string
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			list = append(list, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if str, ok := item.(string); ok && str != "" {
+				list = append(list, str)
+			}
+		}
 	}
+	return list
+}
 
-	config.ExecTimeout = 60 * time.Second
-	if val, ok := scoutOptions["exec_timeout_seconds"].(float64); ok {
-		config.ExecTimeout = time.Duration(val) * time.Second
+// Read the script server list, either plain URLs in priority order or
+// {"url": ..., "priority": n} objects where a lower priority is tried first
+func getServerList(value interface{}) []string {
+	type server struct {
+		url      string
+		priority float64
 	}
+	var servers []server
 
-	// Set the CacheDir to the directory of the config path
-	config.CacheDir = filepath.Join(filepath.Dir(configPath), cacheDirName)
-	if dir, ok := scoutOptions["cache_dir"].(string); ok && dir != "" {
-		config.CacheDir = dir
+	list, _ := value.([]interface{})
+	for i, item := range list {
+		switch v := item.(type) {
+		case string:
+			servers = append(servers, server{url: v, priority: float64(i)})
+		case map[string]interface{}:
+			serverURL, _ := v["url"].(string)
+			priority, ok := v["priority"].(float64)
+			if !ok {
+				priority = float64(i)
+			}
+			servers = append(servers, server{url: serverURL, priority: priority})
+		}
 	}
 
-	// Assign to the package-level variable
-	scoutConfig = config
+	sort.SliceStable(servers, func(i, j int) bool {
+		return servers[i].priority < servers[j].priority
+	})
 
-	return config, nil
+	var urls []string
+	for _, s := range servers {
+		if s.url != "" {
+			urls = append(urls, s.url)
+		}
+	}
+	return urls
 }
 
 func processContextConstraints(queryContext table.QueryContext, columnName string) []string {
@@ -147,6 +302,56 @@ func processContextConstraints(queryContext table.QueryContext, columnName strin
 	return constraints
 }
 
+// Helper function to check a value against the equals and LIKE constraints on a column, for
+// tables that filter their own rows. Equals values are alternatives, as from an IN list, and
+// LIKE patterns must all match. osquery applies the constraints again after us.
+func matchesConstraints(queryContext table.QueryContext, columnName string, value string) bool {
+	constraintList, present := queryContext.Constraints[columnName]
+	if !present {
+		return true
+	}
+	equals := false
+	equalsMatched := false
+	for _, constraint := range constraintList.Constraints {
+		switch constraint.Operator {
+		case table.OperatorEquals:
+			equals = true
+			if constraint.Expression == value {
+				equalsMatched = true
+			}
+		case table.OperatorLike:
+			if !matchLike(constraint.Expression, value) {
+				return false
+			}
+		}
+	}
+	return !equals || equalsMatched
+}
+
+// Helper function to match a value against a SQL LIKE pattern the way SQLite does: % matches
+// any run of characters, _ any single character, and ASCII letters match either case
+func matchLike(pattern string, value string) bool {
+	var expr strings.Builder
+	// Not the i flag, which folds case for every Unicode letter where SQLite only folds ASCII
+	expr.WriteString("(?s)^")
+	for _, c := range pattern {
+		switch {
+		case c == '%':
+			expr.WriteString(".*")
+		case c == '_':
+			expr.WriteString(".")
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			lower := unicode.ToLower(c)
+			fmt.Fprintf(&expr, "[%c%c]", lower, unicode.ToUpper(lower))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	return err == nil && re.MatchString(value)
+}
+
 func processBoolConstraint(val string) bool {
 	switch strings.ToLower(val) {
 	case "0", "false":
@@ -169,14 +374,3 @@ func ensureCacheDir(cacheDir string) error {
 	}
 	return nil
 }
-
-// ensureCacheDB ensures that the cache database exists
-func ensureCacheDB(cacheDir string) error {
-	dbPath := filepath.Join(cacheDir, "scout_cache.db")
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		if err := createCacheDB(dbPath); err != nil {
-			return fmt.Errorf("failed to create cache database %s: %v", dbPath, err)
-		}
-	}
-	return nil
-}
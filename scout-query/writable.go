@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+
+	"github.com/osquery/osquery-go/gen/osquery"
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// DeleteFunc removes the row osquery identifies by its rowid
+type DeleteFunc func(ctx context.Context, rowID string) error
+
+// writableTablePlugin extends a table plugin with osquery's writable table actions. The
+// osquery-go table plugin only answers "generate" and "columns", so "delete" is handled here
+// and "insert"/"update" are refused.
+type writableTablePlugin struct {
+	*table.Plugin
+	delete  DeleteFunc
+	enabled func() bool
+}
+
+func newWritableTablePlugin(name string, columns []table.ColumnDefinition, gen table.GenerateFunc, del DeleteFunc, enabled func() bool) *writableTablePlugin {
+	return &writableTablePlugin{
+		Plugin:  table.NewPlugin(name, columns, gen),
+		delete:  del,
+		enabled: enabled,
+	}
+}
+
+func (t *writableTablePlugin) Call(ctx context.Context, request osquery.ExtensionPluginRequest) osquery.ExtensionResponse {
+	switch request["action"] {
+	case "delete":
+		if !t.enabled() {
+			return writeResponse(map[string]string{"status": "readonly"})
+		}
+		if err := t.delete(ctx, request["id"]); err != nil {
+			return writeResponse(map[string]string{"status": "failure", "message": err.Error()})
+		}
+		return writeResponse(map[string]string{"status": "success"})
+
+	case "insert", "update":
+		return writeResponse(map[string]string{"status": "readonly"})
+
+	default:
+		return t.Plugin.Call(ctx, request)
+	}
+}
+
+func writeResponse(row map[string]string) osquery.ExtensionResponse {
+	return osquery.ExtensionResponse{
+		Status:   &osquery.ExtensionStatus{Code: 0, Message: "OK"},
+		Response: osquery.ExtensionPluginResponse{row},
+	}
+}